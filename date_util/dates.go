@@ -2,12 +2,16 @@
 package date_util
 
 import (
+	"sync"
 	"time"
 )
 
 const (
 	// Format as yyyyMMdd
 	YMDFormat = "20060102"
+
+	// Format as yyyy-MM-dd, the date portion of RFC3339
+	RFC3339DateFormat = "2006-01-02"
 )
 
 // Clock is the interface that wraps the Now method.
@@ -23,6 +27,39 @@ func (s SystemClock) Now() time.Time {
 	return time.Now()
 }
 
+// FakeClock is a Clock for tests that returns a settable time.
+// FakeClock is safe to use with multiple goroutines.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a FakeClock whose current time is now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of this clock.
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// SetNow sets the current time of this clock.
+func (f *FakeClock) SetNow(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// Advance moves the current time of this clock forward by d.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
 // TimeToDate returns t with the time of day zeroed out and the time zone GMT.
 func TimeToDate(t time.Time) time.Time {
 	y, m, d := t.Date()
@@ -33,3 +70,136 @@ func TimeToDate(t time.Time) time.Time {
 func YMD(year int, month int, day int) time.Time {
 	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
 }
+
+// StartOfWeek returns the date of the most recent weekStart on or before
+// t. Like TimeToDate, the returned date has the time of day zeroed out and
+// the time zone GMT.
+func StartOfWeek(t time.Time, weekStart time.Weekday) time.Time {
+	date := TimeToDate(t)
+	daysBack := (int(date.Weekday()) - int(weekStart) + 7) % 7
+	return date.AddDate(0, 0, -daysBack)
+}
+
+// FirstOfMonth returns the first day of the month containing t. Like
+// TimeToDate, the returned date has the time of day zeroed out and
+// the time zone GMT.
+func FirstOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return YMD(y, int(m), 1)
+}
+
+// LastOfMonth returns the last day of the month containing t. Like
+// TimeToDate, the returned date has the time of day zeroed out and
+// the time zone GMT.
+func LastOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return YMD(y, int(m)+1, 0)
+}
+
+// ParseDate parses value according to layout and returns the date in UTC
+// time zone with the time of day zeroed out. If value is the zero
+// time.Time formatted with layout, such as "00010101" for YMDFormat,
+// ParseDate returns the zero time.Time.
+func ParseDate(layout, value string) (time.Time, error) {
+	if IsZeroDate(layout, value) {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return TimeToDate(t), nil
+}
+
+// IsZeroDate returns true if value is the zero time.Time formatted with
+// layout, such as "00010101" for YMDFormat.
+func IsZeroDate(layout, value string) bool {
+	return value == time.Time{}.Format(layout)
+}
+
+// Iterate returns start and each time that step produces after start up
+// to and including end. step returns the time following the time passed
+// to it and must return a time later than the time passed to it.
+// If end is before start, Iterate returns nil.
+func Iterate(
+	start, end time.Time, step func(time.Time) time.Time) []time.Time {
+	var result []time.Time
+	for t := start; !t.After(end); t = step(t) {
+		result = append(result, t)
+	}
+	return result
+}
+
+// NextDay returns the day after t. NextDay can be used with Iterate.
+func NextDay(t time.Time) time.Time {
+	return t.AddDate(0, 0, 1)
+}
+
+// NextMonth returns the first day of the month after t. Like TimeToDate,
+// the returned date has the time of day zeroed out and the time zone GMT.
+// NextMonth can be used with Iterate.
+func NextMonth(t time.Time) time.Time {
+	return FirstOfMonth(t).AddDate(0, 1, 0)
+}
+
+// AddBusinessDays returns the date n business days after t skipping
+// Saturdays, Sundays, and holidays. If n is negative, AddBusinessDays
+// counts backward. If n is 0, AddBusinessDays returns the date of t even
+// if it is not a business day. Like TimeToDate, the returned date has the
+// time of day zeroed out and the time zone GMT. Only the dates of holidays
+// matter.
+func AddBusinessDays(t time.Time, n int, holidays ...time.Time) time.Time {
+	holidaySet := toDateSet(holidays)
+	step := 1
+	if n < 0 {
+		step = -1
+		n = -n
+	}
+	result := TimeToDate(t)
+	for n > 0 {
+		result = result.AddDate(0, 0, step)
+		if isBusinessDay(result, holidaySet) {
+			n--
+		}
+	}
+	return result
+}
+
+// BusinessDaysBetween returns the number of business days after a up to
+// and including b skipping Saturdays, Sundays, and holidays. If b is
+// before a, BusinessDaysBetween returns the negative of the number of
+// business days after b up to and including a. Only the dates of a, b,
+// and holidays matter. If b is a business day, AddBusinessDays(a,
+// BusinessDaysBetween(a, b, holidays...), holidays...) is the date of b.
+func BusinessDaysBetween(a, b time.Time, holidays ...time.Time) int {
+	start, end := TimeToDate(a), TimeToDate(b)
+	sign := 1
+	if end.Before(start) {
+		start, end = end, start
+		sign = -1
+	}
+	holidaySet := toDateSet(holidays)
+	result := 0
+	for d := NextDay(start); !d.After(end); d = NextDay(d) {
+		if isBusinessDay(d, holidaySet) {
+			result++
+		}
+	}
+	return sign * result
+}
+
+func isBusinessDay(date time.Time, holidays map[time.Time]bool) bool {
+	weekday := date.Weekday()
+	if weekday == time.Saturday || weekday == time.Sunday {
+		return false
+	}
+	return !holidays[date]
+}
+
+func toDateSet(times []time.Time) map[time.Time]bool {
+	result := make(map[time.Time]bool, len(times))
+	for _, t := range times {
+		result[TimeToDate(t)] = true
+	}
+	return result
+}
@@ -2,6 +2,7 @@ package date_util_test
 
 import (
 	"github.com/keep94/toolbox/date_util"
+	"sync"
 	"testing"
 	"time"
 )
@@ -13,3 +14,198 @@ func TestYMD(t *testing.T) {
 		t.Errorf("Expected %v, got %v", expected, actual)
 	}
 }
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2013, 11, 14, 10, 30, 0, 0, time.UTC)
+	clock := date_util.NewFakeClock(start)
+	var c date_util.Clock = clock
+	if actual := c.Now(); actual != start {
+		t.Errorf("Expected %v, got %v", start, actual)
+	}
+	clock.Advance(90 * time.Minute)
+	expected := start.Add(90 * time.Minute)
+	if actual := c.Now(); actual != expected {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	later := date_util.YMD(2014, 1, 1)
+	clock.SetNow(later)
+	if actual := c.Now(); actual != later {
+		t.Errorf("Expected %v, got %v", later, actual)
+	}
+}
+
+func TestFakeClockConcurrent(t *testing.T) {
+	start := date_util.YMD(2013, 11, 14)
+	clock := date_util.NewFakeClock(start)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			clock.Advance(time.Second)
+		}()
+		go func() {
+			defer wg.Done()
+			clock.Now()
+		}()
+	}
+	wg.Wait()
+	expected := start.Add(10 * time.Second)
+	if actual := clock.Now(); actual != expected {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestStartOfWeek(t *testing.T) {
+	// Thursday
+	thursday := time.Date(2013, 11, 14, 22, 15, 0, 0, time.UTC)
+	assertDate(t, date_util.YMD(2013, 11, 10), date_util.StartOfWeek(thursday, time.Sunday))
+	assertDate(t, date_util.YMD(2013, 11, 11), date_util.StartOfWeek(thursday, time.Monday))
+	assertDate(t, date_util.YMD(2013, 11, 14), date_util.StartOfWeek(thursday, time.Thursday))
+	assertDate(t, date_util.YMD(2013, 11, 8), date_util.StartOfWeek(thursday, time.Friday))
+	// Across a year boundary
+	assertDate(t, date_util.YMD(2013, 12, 30), date_util.StartOfWeek(date_util.YMD(2014, 1, 2), time.Monday))
+}
+
+func TestFirstOfMonth(t *testing.T) {
+	assertDate(t, date_util.YMD(2013, 11, 1), date_util.FirstOfMonth(time.Date(2013, 11, 14, 22, 15, 0, 0, time.UTC)))
+	assertDate(t, date_util.YMD(2013, 12, 1), date_util.FirstOfMonth(date_util.YMD(2013, 12, 31)))
+	assertDate(t, date_util.YMD(2014, 1, 1), date_util.FirstOfMonth(date_util.YMD(2014, 1, 1)))
+}
+
+func TestLastOfMonth(t *testing.T) {
+	assertDate(t, date_util.YMD(2012, 2, 29), date_util.LastOfMonth(date_util.YMD(2012, 2, 10)))
+	assertDate(t, date_util.YMD(2013, 2, 28), date_util.LastOfMonth(date_util.YMD(2013, 2, 28)))
+	assertDate(t, date_util.YMD(2000, 2, 29), date_util.LastOfMonth(date_util.YMD(2000, 2, 1)))
+	assertDate(t, date_util.YMD(2100, 2, 28), date_util.LastOfMonth(date_util.YMD(2100, 2, 1)))
+	assertDate(t, date_util.YMD(2013, 4, 30), date_util.LastOfMonth(date_util.YMD(2013, 4, 1)))
+	assertDate(t, date_util.YMD(2013, 12, 31), date_util.LastOfMonth(time.Date(2013, 12, 31, 23, 59, 0, 0, time.UTC)))
+	assertDate(t, date_util.YMD(2014, 1, 31), date_util.LastOfMonth(date_util.YMD(2014, 1, 1)))
+}
+
+func assertDate(t *testing.T, expected, actual time.Time) {
+	t.Helper()
+	if expected != actual {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	actual, err := date_util.ParseDate(date_util.YMDFormat, "20131114")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDate(t, date_util.YMD(2013, 11, 14), actual)
+	actual, err = date_util.ParseDate(date_util.RFC3339DateFormat, "2012-02-29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDate(t, date_util.YMD(2012, 2, 29), actual)
+	if _, err := date_util.ParseDate(date_util.YMDFormat, "2013-11-14"); err == nil {
+		t.Error("Expected error parsing wrong layout")
+	}
+}
+
+func TestParseDateZero(t *testing.T) {
+	if !date_util.IsZeroDate(date_util.YMDFormat, "00010101") {
+		t.Error("Expected 00010101 to be the zero date")
+	}
+	if !date_util.IsZeroDate(date_util.RFC3339DateFormat, "0001-01-01") {
+		t.Error("Expected 0001-01-01 to be the zero date")
+	}
+	if date_util.IsZeroDate(date_util.YMDFormat, "20131114") {
+		t.Error("Expected 20131114 not to be the zero date")
+	}
+	actual, err := date_util.ParseDate(date_util.YMDFormat, "00010101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !actual.IsZero() {
+		t.Errorf("Expected zero time, got %v", actual)
+	}
+}
+
+func TestIterateMonths(t *testing.T) {
+	actual := date_util.Iterate(
+		date_util.YMD(2013, 11, 15),
+		date_util.YMD(2014, 2, 1),
+		date_util.NextMonth)
+	expected := []time.Time{
+		date_util.YMD(2013, 11, 15),
+		date_util.YMD(2013, 12, 1),
+		date_util.YMD(2014, 1, 1),
+		date_util.YMD(2014, 2, 1),
+	}
+	assertDates(t, expected, actual)
+}
+
+func TestIterateDays(t *testing.T) {
+	actual := date_util.Iterate(
+		date_util.YMD(2012, 2, 28),
+		date_util.YMD(2012, 3, 1),
+		date_util.NextDay)
+	expected := []time.Time{
+		date_util.YMD(2012, 2, 28),
+		date_util.YMD(2012, 2, 29),
+		date_util.YMD(2012, 3, 1),
+	}
+	assertDates(t, expected, actual)
+	if actual := date_util.Iterate(
+		date_util.YMD(2012, 3, 1),
+		date_util.YMD(2012, 2, 28),
+		date_util.NextDay); actual != nil {
+		t.Errorf("Expected nil, got %v", actual)
+	}
+}
+
+func assertDates(t *testing.T, expected, actual []time.Time) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		assertDate(t, expected[i], actual[i])
+	}
+}
+
+func TestAddBusinessDays(t *testing.T) {
+	// 2024-03-01 is a Friday
+	friday := time.Date(2024, 3, 1, 17, 30, 0, 0, time.UTC)
+	assertDate(t, date_util.YMD(2024, 3, 4), date_util.AddBusinessDays(friday, 1))
+	assertDate(t, date_util.YMD(2024, 3, 8), date_util.AddBusinessDays(friday, 5))
+	assertDate(t, date_util.YMD(2024, 3, 1), date_util.AddBusinessDays(friday, 0))
+	assertDate(
+		t,
+		date_util.YMD(2024, 3, 5),
+		date_util.AddBusinessDays(friday, 1, date_util.YMD(2024, 3, 4)))
+	monday := date_util.YMD(2024, 3, 4)
+	assertDate(t, date_util.YMD(2024, 3, 1), date_util.AddBusinessDays(monday, -1))
+	assertDate(
+		t,
+		date_util.YMD(2024, 2, 29),
+		date_util.AddBusinessDays(monday, -1, date_util.YMD(2024, 3, 1)))
+	sunday := date_util.YMD(2024, 3, 3)
+	assertDate(t, date_util.YMD(2024, 3, 4), date_util.AddBusinessDays(sunday, 1))
+}
+
+func TestBusinessDaysBetween(t *testing.T) {
+	friday := date_util.YMD(2024, 3, 1)
+	nextFriday := date_util.YMD(2024, 3, 8)
+	if actual := date_util.BusinessDaysBetween(friday, nextFriday); actual != 5 {
+		t.Errorf("Expected 5, got %d", actual)
+	}
+	if actual := date_util.BusinessDaysBetween(nextFriday, friday); actual != -5 {
+		t.Errorf("Expected -5, got %d", actual)
+	}
+	if actual := date_util.BusinessDaysBetween(
+		friday, nextFriday, date_util.YMD(2024, 3, 6)); actual != 4 {
+		t.Errorf("Expected 4, got %d", actual)
+	}
+	if actual := date_util.BusinessDaysBetween(
+		friday, date_util.YMD(2024, 3, 3)); actual != 0 {
+		t.Errorf("Expected 0, got %d", actual)
+	}
+	if actual := date_util.BusinessDaysBetween(friday, friday); actual != 0 {
+		t.Errorf("Expected 0, got %d", actual)
+	}
+}
@@ -2,8 +2,13 @@
 package sqlite3_db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/keep94/toolbox/date_util"
@@ -17,8 +22,47 @@ type Action func(tx *sql.Tx) error
 // With Db, multiple goroutines can safely share the same connection.
 // Db also provides transactional behavior.
 type Db struct {
-	mu sync.Mutex
-	db *sql.DB
+	mu          sync.Mutex
+	db          *sql.DB
+	busyRetries int
+	busyBackoff time.Duration
+	slow        time.Duration
+	slowLog     func(d time.Duration)
+	stats       Stats
+}
+
+// Stats contains statistics on the transactions of a Db.
+type Stats struct {
+
+	// The number of transactions started
+	Transactions int64
+
+	// The number of transactions rolled back
+	Rollbacks int64
+}
+
+// Option represents an optional setting for NewWithOptions.
+type Option func(d *Db)
+
+// RetryOnBusy makes Db retry a transaction up to retries more times when
+// it fails because the database is busy or locked. Db waits backoff
+// before each retry. Each retry runs the entire action again as the
+// failed transaction was rolled back.
+func RetryOnBusy(retries int, backoff time.Duration) Option {
+	return func(d *Db) {
+		d.busyRetries = retries
+		d.busyBackoff = backoff
+	}
+}
+
+// SlowThreshold makes Db call logf with the duration of each transaction
+// taking at least d. Db calls logf after the transaction finishes, outside
+// the lock that serializes transactions.
+func SlowThreshold(d time.Duration, logf func(d time.Duration)) Option {
+	return func(db *Db) {
+		db.slow = d
+		db.slowLog = logf
+	}
 }
 
 // New creates a new Db.
@@ -26,27 +70,173 @@ func New(db *sql.DB) *Db {
 	return &Db{db: db}
 }
 
+// NewWithOptions creates a new Db with options.
+func NewWithOptions(db *sql.DB, options ...Option) *Db {
+	result := New(db)
+	for _, option := range options {
+		option(result)
+	}
+	return result
+}
+
 // Do performs action within a transaction.
 func (d *Db) Do(action Action) error {
+	return d.DoContext(context.Background(), action)
+}
+
+// DoContext performs action within a transaction bound to ctx. If ctx is
+// canceled or times out, the transaction is rolled back.
+func (d *Db) DoContext(ctx context.Context, action Action) error {
+	return d.DoWithOptions(ctx, nil, action)
+}
+
+// DoReadOnly performs action within a read-only transaction. Any attempt
+// to write to the database within action fails.
+func (d *Db) DoReadOnly(action Action) error {
+	return d.DoWithOptions(
+		context.Background(), &sql.TxOptions{ReadOnly: true}, action)
+}
+
+// DoWithOptions performs action within a transaction bound to ctx.
+// Of opts, DoWithOptions honors only ReadOnly. Because sqlite3
+// transactions are always serializable, DoWithOptions returns an error
+// without running action if opts asks for an isolation level other than
+// sql.LevelDefault or sql.LevelSerializable. opts may be nil in which case
+// the defaults are used.
+func (d *Db) DoWithOptions(
+	ctx context.Context, opts *sql.TxOptions, action Action) error {
+	if opts != nil && opts.Isolation != sql.LevelDefault &&
+		opts.Isolation != sql.LevelSerializable {
+		return fmt.Errorf(
+			"sqlite3_db: unsupported isolation level %v", opts.Isolation)
+	}
+	err := d.do(ctx, opts, action)
+	for i := 0; i < d.busyRetries && isBusy(err); i++ {
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(d.busyBackoff):
+		}
+		err = d.do(ctx, opts, action)
+	}
+	return err
+}
+
+// Stats returns the statistics of the transactions of this instance.
+func (d *Db) Stats() Stats {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	tx, err := d.db.Begin()
+	return d.stats
+}
+
+func (d *Db) do(
+	ctx context.Context, opts *sql.TxOptions, action Action) error {
+	d.mu.Lock()
+	start := time.Now()
+	err := d.doLocked(ctx, opts, action)
+	elapsed := time.Since(start)
+	d.mu.Unlock()
+	if d.slowLog != nil && elapsed >= d.slow {
+		d.slowLog(elapsed)
+	}
+	return err
+}
+
+func (d *Db) doLocked(
+	ctx context.Context, opts *sql.TxOptions, action Action) error {
+	if opts != nil && opts.ReadOnly {
+		return d.doReadOnlyLocked(ctx, opts, action)
+	}
+	tx, err := d.db.BeginTx(ctx, opts)
 	if err != nil {
 		return err
 	}
-	err = action(tx)
+	return d.runTx(tx, action)
+}
+
+// doReadOnlyLocked pins a connection for the transaction because
+// PRAGMA query_only applies to the connection, not the transaction. If
+// resetting query_only fails, doReadOnlyLocked discards the connection so
+// that it never goes back to the pool read-only.
+func (d *Db) doReadOnlyLocked(
+	ctx context.Context, opts *sql.TxOptions, action Action) error {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := setQueryOnly(conn, true); err != nil {
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, opts)
+	if err == nil {
+		err = d.runTx(tx, action)
+	}
+	if resetErr := setQueryOnly(conn, false); resetErr != nil {
+		conn.Raw(func(driverConn interface{}) error {
+			return driver.ErrBadConn
+		})
+		if err == nil {
+			err = resetErr
+		}
+	}
+	return err
+}
+
+func (d *Db) runTx(tx *sql.Tx, action Action) error {
+	d.stats.Transactions++
+	err := action(tx)
 	if err != nil {
 		tx.Rollback()
+		d.stats.Rollbacks++
 		return err
 	}
 	err = tx.Commit()
 	if err != nil {
 		tx.Rollback()
+		d.stats.Rollbacks++
 		return err
 	}
 	return nil
 }
 
+// isBusy reports whether err is a SQLITE_BUSY or SQLITE_LOCKED error.
+// isBusy matches the messages that sqlite3 reports for these errors
+// rather than the error types of a particular driver.
+func isBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, busyMsg := range kBusyMessages {
+		if strings.Contains(msg, busyMsg) {
+			return true
+		}
+	}
+	return false
+}
+
+var kBusyMessages = []string{
+	"database is locked",
+	"database table is locked",
+	"database schema is locked",
+}
+
+// setQueryOnly enforces read-only transactions because the sqlite3 driver
+// ignores the ReadOnly field of sql.TxOptions. setQueryOnly ignores any
+// context so that it can reset a connection after a canceled transaction.
+func setQueryOnly(conn *sql.Conn, on bool) error {
+	var err error
+	if on {
+		_, err = conn.ExecContext(
+			context.Background(), "PRAGMA query_only = 1")
+	} else {
+		_, err = conn.ExecContext(
+			context.Background(), "PRAGMA query_only = 0")
+	}
+	return err
+}
+
 // Close closes the underlying sql.DB instance.
 func (d *Db) Close() error {
 	d.mu.Lock()
@@ -62,13 +252,16 @@ func DateToString(t time.Time) string {
 // StringToDate converts a string of form YYYYmmdd to a time object in UTC
 // time zone.
 func StringToDate(s string) (t time.Time, e error) {
-	if s == "00010101" {
-		return time.Time{}, nil
-	}
-	return time.Parse(date_util.YMDFormat, s)
+	return date_util.ParseDate(date_util.YMDFormat, s)
 }
 
 func NewDoer(db *Db) db.Doer {
+	return NewContextDoer(db)
+}
+
+// NewContextDoer works like NewDoer except that the returned Doer can also
+// perform actions bound to a context.
+func NewContextDoer(db *Db) db.ContextDoer {
 	return genericDoer{db}
 }
 
@@ -90,6 +283,18 @@ func ToDoer(db Doer, t db.Transaction) Doer {
 	return t.(Doer)
 }
 
+// ToSavepointDoer works like ToDoer except that if t is a transaction
+// that a Doer from NewDoer passed to its action, the returned Doer runs
+// each action within a savepoint of that transaction so that if the
+// action fails, only the changes the action made are rolled back and the
+// enclosing transaction can still commit.
+func ToSavepointDoer(db Doer, t db.Transaction) Doer {
+	if s, ok := t.(simpleDoer); ok {
+		return savepointDoer{s.tx}
+	}
+	return ToDoer(db, t)
+}
+
 type genericDoer struct {
 	db *Db
 }
@@ -100,6 +305,13 @@ func (g genericDoer) Do(action db.Action) error {
 	})
 }
 
+func (g genericDoer) DoContext(
+	ctx context.Context, action db.Action) error {
+	return g.db.DoContext(ctx, func(tx *sql.Tx) error {
+		return action(toTransaction(tx))
+	})
+}
+
 func toTransaction(tx *sql.Tx) db.Transaction {
 	return simpleDoer{tx}
 }
@@ -111,3 +323,31 @@ type simpleDoer struct {
 func (s simpleDoer) Do(a Action) error {
 	return a(s.tx)
 }
+
+type savepointDoer struct {
+	tx *sql.Tx
+}
+
+func (s savepointDoer) Do(a Action) error {
+	name := fmt.Sprintf(
+		"toolbox_savepoint_%d", atomic.AddUint64(&savepointCount, 1))
+	if _, err := s.tx.Exec("SAVEPOINT " + name); err != nil {
+		return err
+	}
+	if err := a(s.tx); err != nil {
+		if _, rerr := s.tx.Exec("ROLLBACK TO " + name); rerr != nil {
+			return fmt.Errorf(
+				"sqlite3_db: rolling back savepoint after %v: %w", err, rerr)
+		}
+		if _, rerr := s.tx.Exec("RELEASE " + name); rerr != nil {
+			return fmt.Errorf(
+				"sqlite3_db: releasing savepoint after %v: %w", err, rerr)
+		}
+		return err
+	}
+	_, err := s.tx.Exec("RELEASE " + name)
+	return err
+}
+
+// savepointCount gives each savepoint a unique name.
+var savepointCount uint64
@@ -0,0 +1,381 @@
+package sqlite3_db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keep94/toolbox/db"
+	"github.com/keep94/toolbox/db/sqlite3_db"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoContext(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	db := sqlite3_db.New(rawdb)
+	defer db.Close()
+	var actionRun bool
+	assert.Nil(db.DoContext(context.Background(), func(tx *sql.Tx) error {
+		actionRun = true
+		return createTable(tx)
+	}))
+	assert.True(actionRun)
+}
+
+func TestDoContextCanceled(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	db := sqlite3_db.New(rawdb)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var actionRun bool
+	assert.Equal(context.Canceled, db.DoContext(ctx, func(tx *sql.Tx) error {
+		actionRun = true
+		return nil
+	}))
+	assert.False(actionRun)
+}
+
+func TestContextDoer(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	sdb := sqlite3_db.New(rawdb)
+	defer sdb.Close()
+	doer := sqlite3_db.NewContextDoer(sdb)
+	var actionRun bool
+	assert.Nil(doer.DoContext(
+		context.Background(), func(t db.Transaction) error {
+			actionRun = true
+			return sqlite3_db.ToDoer(nil, t).Do(createTable)
+		}))
+	assert.True(actionRun)
+
+	// The plain Doer from NewDoer is also a ContextDoer.
+	_, ok := sqlite3_db.NewDoer(sdb).(db.ContextDoer)
+	assert.True(ok)
+}
+
+func TestContextDoerCanceled(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	sdb := sqlite3_db.New(rawdb)
+	defer sdb.Close()
+	doer := sqlite3_db.NewContextDoer(sdb)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var actionRun bool
+	assert.Equal(context.Canceled, doer.DoContext(
+		ctx, func(t db.Transaction) error {
+			actionRun = true
+			return nil
+		}))
+	assert.False(actionRun)
+}
+
+func TestSavepoint(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	sdb := sqlite3_db.New(rawdb)
+	defer sdb.Close()
+	assert.Nil(sdb.Do(createTable))
+	innerErr := errors.New("inner failed")
+	doer := sqlite3_db.NewDoer(sdb)
+	assert.Nil(doer.Do(func(t db.Transaction) error {
+		err := sqlite3_db.ToSavepointDoer(sdb, t).Do(func(tx *sql.Tx) error {
+			return insertRecord(tx, "outer")
+		})
+		if err != nil {
+			return err
+		}
+		err = sqlite3_db.ToSavepointDoer(sdb, t).Do(func(tx *sql.Tx) error {
+			if err := insertRecord(tx, "inner"); err != nil {
+				return err
+			}
+			return innerErr
+		})
+		assert.Equal(innerErr, err)
+
+		// The outer transaction continues after the inner one rolls back
+		return sqlite3_db.ToSavepointDoer(sdb, t).Do(func(tx *sql.Tx) error {
+			return insertRecord(tx, "after")
+		})
+	}))
+	var names []string
+	assert.Nil(sdb.Do(func(tx *sql.Tx) error {
+		rows, err := tx.Query("select name from records order by id")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		return rows.Err()
+	}))
+	assert.Equal([]string{"outer", "after"}, names)
+}
+
+func TestNestedSavepoint(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	sdb := sqlite3_db.New(rawdb)
+	defer sdb.Close()
+	assert.Nil(sdb.Do(createTable))
+	innerErr := errors.New("inner failed")
+	doer := sqlite3_db.NewDoer(sdb)
+	assert.Nil(doer.Do(func(t db.Transaction) error {
+		return sqlite3_db.ToSavepointDoer(sdb, t).Do(func(tx *sql.Tx) error {
+			if err := insertRecord(tx, "outer"); err != nil {
+				return err
+			}
+			err := sqlite3_db.ToSavepointDoer(sdb, t).Do(func(tx *sql.Tx) error {
+				if err := insertRecord(tx, "inner"); err != nil {
+					return err
+				}
+				return innerErr
+			})
+			assert.Equal(innerErr, err)
+			return insertRecord(tx, "after")
+		})
+	}))
+	var count int
+	assert.Nil(sdb.Do(func(tx *sql.Tx) error {
+		return tx.QueryRow(
+			"select count(*) from records where name = 'inner'").Scan(&count)
+	}))
+	assert.Equal(0, count)
+	assert.Nil(sdb.Do(func(tx *sql.Tx) error {
+		return tx.QueryRow("select count(*) from records").Scan(&count)
+	}))
+	assert.Equal(2, count)
+}
+
+func TestDoReadOnly(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	db := sqlite3_db.New(rawdb)
+	defer db.Close()
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		if err := createTable(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			"insert into records (name, phone) values (?, ?)", "a", "1")
+		return err
+	}))
+	var name string
+	assert.Nil(db.DoReadOnly(func(tx *sql.Tx) error {
+		return tx.QueryRow(
+			"select name from records where id = ?", 1).Scan(&name)
+	}))
+	assert.Equal("a", name)
+	assert.Error(db.DoReadOnly(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"insert into records (name, phone) values (?, ?)", "b", "2")
+		return err
+	}))
+
+	// Writes work again after a read-only transaction
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"insert into records (name, phone) values (?, ?)", "c", "3")
+		return err
+	}))
+	var count int
+	assert.Nil(db.DoWithOptions(
+		context.Background(),
+		&sql.TxOptions{ReadOnly: true},
+		func(tx *sql.Tx) error {
+			return tx.QueryRow("select count(*) from records").Scan(&count)
+		}))
+	assert.Equal(2, count)
+}
+
+func TestDoWithOptionsIsolation(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	db := sqlite3_db.New(rawdb)
+	defer db.Close()
+	assert.Nil(db.Do(createTable))
+	assert.Nil(db.DoWithOptions(
+		context.Background(),
+		&sql.TxOptions{Isolation: sql.LevelSerializable},
+		func(tx *sql.Tx) error {
+			return insertRecord(tx, "a")
+		}))
+	actionRun := false
+	assert.Error(db.DoWithOptions(
+		context.Background(),
+		&sql.TxOptions{Isolation: sql.LevelReadCommitted},
+		func(tx *sql.Tx) error {
+			actionRun = true
+			return nil
+		}))
+	assert.False(actionRun)
+}
+
+func TestDoReadOnlyCanceled(t *testing.T) {
+	assert := assert.New(t)
+
+	// A file rather than :memory: so that the records survive if the
+	// pool discards the connection of the canceled read.
+	rawdb, _ := sql.Open(
+		"sqlite3", filepath.Join(t.TempDir(), "readonly.db"))
+
+	// A single connection so that the write below reuses the connection
+	// of the canceled read if the pool keeps it.
+	rawdb.SetMaxOpenConns(1)
+	db := sqlite3_db.New(rawdb)
+	defer db.Close()
+	assert.Nil(db.Do(createTable))
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Error(db.DoWithOptions(
+		ctx,
+		&sql.TxOptions{ReadOnly: true},
+		func(tx *sql.Tx) error {
+			cancel()
+
+			// Wait for database/sql to roll back the transaction.
+			for {
+				var count int
+				err := tx.QueryRow("select count(*) from records").Scan(&count)
+				if err == sql.ErrTxDone {
+					return err
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}))
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return insertRecord(tx, "a")
+	}))
+
+	// Ending the transaction within the action must not leave the
+	// connection read-only either.
+	assert.Error(db.DoReadOnly(func(tx *sql.Tx) error {
+		return tx.Rollback()
+	}))
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return insertRecord(tx, "b")
+	}))
+}
+
+func TestRetryOnBusy(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	db := sqlite3_db.NewWithOptions(
+		rawdb, sqlite3_db.RetryOnBusy(5, time.Millisecond))
+	defer db.Close()
+	assert.Nil(db.Do(createTable))
+	var tries int
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		tries++
+		if _, err := tx.Exec(
+			"insert into records (name, phone) values (?, ?)",
+			"a", "1"); err != nil {
+			return err
+		}
+		if tries <= 2 {
+			return errors.New("database is locked")
+		}
+		return nil
+	}))
+	assert.Equal(3, tries)
+
+	// Only the last try should have committed
+	var count int
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return tx.QueryRow("select count(*) from records").Scan(&count)
+	}))
+	assert.Equal(1, count)
+}
+
+func TestRetryOnBusyGivesUp(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	db := sqlite3_db.NewWithOptions(
+		rawdb, sqlite3_db.RetryOnBusy(2, time.Millisecond))
+	defer db.Close()
+	var tries int
+	busyErr := fmt.Errorf(
+		"sqlite3_db_test: %w", errors.New("database table is locked"))
+	assert.Equal(busyErr, db.Do(func(tx *sql.Tx) error {
+		tries++
+		return busyErr
+	}))
+	assert.Equal(3, tries)
+}
+
+func TestNoRetryOnOtherErrors(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	db := sqlite3_db.NewWithOptions(
+		rawdb, sqlite3_db.RetryOnBusy(5, time.Millisecond))
+	defer db.Close()
+	var tries int
+	otherErr := errors.New("sqlite3_db_test: some error")
+	assert.Equal(otherErr, db.Do(func(tx *sql.Tx) error {
+		tries++
+		return otherErr
+	}))
+	assert.Equal(1, tries)
+}
+
+func TestSlowThreshold(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	var slow []time.Duration
+	sdb := sqlite3_db.NewWithOptions(
+		rawdb,
+		sqlite3_db.SlowThreshold(50*time.Millisecond, func(d time.Duration) {
+			slow = append(slow, d)
+		}))
+	defer sdb.Close()
+	assert.Nil(sdb.Do(createTable))
+	assert.Empty(slow)
+	assert.Nil(sdb.Do(func(tx *sql.Tx) error {
+		time.Sleep(60 * time.Millisecond)
+		return nil
+	}))
+	assert.Len(slow, 1)
+	assert.True(slow[0] >= 60*time.Millisecond)
+}
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	sdb := sqlite3_db.New(rawdb)
+	defer sdb.Close()
+	assert.Equal(sqlite3_db.Stats{}, sdb.Stats())
+	assert.Nil(sdb.Do(createTable))
+	failed := errors.New("failed")
+	assert.Equal(failed, sdb.Do(func(tx *sql.Tx) error {
+		return failed
+	}))
+	assert.Nil(sdb.DoReadOnly(func(tx *sql.Tx) error {
+		return nil
+	}))
+	assert.Equal(
+		sqlite3_db.Stats{Transactions: 3, Rollbacks: 1}, sdb.Stats())
+}
+
+func createTable(tx *sql.Tx) error {
+	_, err := tx.Exec("create table if not exists records (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, phone TEXT)")
+	return err
+}
+
+func insertRecord(tx *sql.Tx, name string) error {
+	_, err := tx.Exec(
+		"insert into records (name, phone) values (?, ?)", name, "")
+	return err
+}
@@ -3,13 +3,28 @@
 package sqlite3_rw
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/keep94/consume2"
 )
 
+var (
+	// ErrConcurrentModification is returned when a row was modified since
+	// it was read.
+	ErrConcurrentModification = errors.New(
+		"sqlite3_rw: Concurrent modification")
+
+	// ErrNoSuchRow is returned when there is no row to delete.
+	ErrNoSuchRow = errors.New("sqlite3_rw: No such row")
+)
+
 // RowForReading reads a single database row into its business object.
 // RowForReading instances can optionally implement EtagSetter if
 // its business object has an etag.
@@ -39,6 +54,13 @@ type RowsForReadingEtagSetter[T any] interface {
 	EtagSetter
 }
 
+// RowForReadingEtagSetter handles both reading a single row and setting
+// etags.
+type RowForReadingEtagSetter interface {
+	RowForReading
+	EtagSetter
+}
+
 // EtagSetter sets the etag on its business objecct
 type EtagSetter interface {
 
@@ -90,6 +112,95 @@ func ReadSingle(
 	return FirstOnly(row, dbrows, noSuchRow)
 }
 
+// ReadSingleNamed works like ReadSingle except that params provides the
+// values for named place holders such as :name in sql.
+func ReadSingleNamed(
+	tx *sql.Tx,
+	row RowForReading,
+	noSuchRow error,
+	sql string,
+	params map[string]interface{}) error {
+	return ReadSingle(tx, row, noSuchRow, sql, namedArgs(params)...)
+}
+
+// ReadScalar executes sql and reads the single column of the first row
+// into dest. ReadScalar is useful for aggregate queries such as
+// COUNT(*). ReadScalar returns sql.ErrNoRows if no rows were found.
+// params provides the values for the question mark (?) place holders in sql.
+func ReadScalar[T any](
+	tx *sql.Tx,
+	dest *T,
+	sql string,
+	params ...interface{}) error {
+	return tx.QueryRow(sql, params...).Scan(dest)
+}
+
+// ReadInto executes sql and appends each row read to dst. T must be a
+// flat struct type. ReadInto stores each column in the field of T tagged
+// with `db:"column_name"`. ReadInto returns an error if a column has no
+// corresponding exported field. ReadInto is an alternative to ReadMultiple for
+// simple queries that don't need a custom RowsForReading implementation.
+// params provides the values for the question mark (?) place holders
+// in sql.
+func ReadInto[T any](
+	tx *sql.Tx,
+	dst *[]T,
+	sql string,
+	params ...interface{}) error {
+	var value T
+	v := reflect.ValueOf(&value).Elem()
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("sqlite3_rw: ReadInto needs a struct, got %v", v.Type())
+	}
+	dbrows, err := tx.Query(sql, params...)
+	if err != nil {
+		return err
+	}
+	defer dbrows.Close()
+	columns, err := dbrows.Columns()
+	if err != nil {
+		return err
+	}
+	fieldIndexes := dbFieldIndexes(v.Type())
+	ptrs := make([]interface{}, len(columns))
+	for i, column := range columns {
+		idx, ok := fieldIndexes[column]
+		if !ok {
+			return fmt.Errorf(
+				"sqlite3_rw: no field in %v tagged db:%q", v.Type(), column)
+		}
+		field := v.Field(idx)
+		if !field.CanSet() {
+			return fmt.Errorf(
+				"sqlite3_rw: field %s of %v tagged db:%q is unexported",
+				v.Type().Field(idx).Name, v.Type(), column)
+		}
+		ptrs[i] = field.Addr().Interface()
+	}
+	for dbrows.Next() {
+		var zero T
+		value = zero
+		if err := dbrows.Scan(ptrs...); err != nil {
+			return err
+		}
+		*dst = append(*dst, value)
+	}
+	return dbrows.Err()
+}
+
+// dbFieldIndexes maps the db tags of the fields in struct type t to the
+// indexes of those fields.
+func dbFieldIndexes(t reflect.Type) map[string]int {
+	result := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("db")
+		if name != "" && name != "-" {
+			result[name] = i
+		}
+	}
+	return result
+}
+
 // FirstOnly reads one row from dbrows into row's business object. FirstOnly
 // returns noSuchRow if dbrows has no rows.
 func FirstOnly(
@@ -120,7 +231,8 @@ func ReadRows[T any](
 	row RowsForReading[T],
 	dbrows *sql.Rows,
 	consumer consume2.Consumer[T]) error {
-	if err := readRows(row, dbrows, consumer, false); err != nil {
+	if err := readRows(
+		context.Background(), row, dbrows, consumer, false, nil); err != nil {
 		return err
 	}
 	return dbrows.Err()
@@ -132,20 +244,29 @@ func ReadRowsWithEtag[T any](
 	row RowsForReadingEtagSetter[T],
 	dbrows *sql.Rows,
 	consumer consume2.Consumer[T]) error {
-	if err := readRows[T](row, dbrows, consumer, true); err != nil {
+	if err := readRows[T](
+		context.Background(), row, dbrows, consumer, true, nil); err != nil {
 		return err
 	}
 	return dbrows.Err()
 }
 
 func readRows[T any](
+	ctx context.Context,
 	row RowsForReading[T],
 	dbrows *sql.Rows,
 	consumer consume2.Consumer[T],
-	setEtag bool) error {
+	setEtag bool,
+	onError func(err error) bool) error {
 	ptrs := row.Ptrs()
 	for dbrows.Next() && consumer.CanConsume() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err := readRow(row, dbrows, ptrs, setEtag); err != nil {
+			if onError != nil && onError(err) {
+				continue
+			}
 			return err
 		}
 		consumer.Consume(row.ValueRead())
@@ -169,7 +290,66 @@ func ReadMultiple[T any](
 		return err
 	}
 	defer dbrows.Close()
-	if err := readRows(row, dbrows, consumer, false); err != nil {
+	if err := readRows(
+		context.Background(), row, dbrows, consumer, false, nil); err != nil {
+		return err
+	}
+	return dbrows.Err()
+}
+
+// ReadMultipleLenient works like ReadMultiple except that when a row
+// can't be read, such as when Unmarshall fails, ReadMultipleLenient calls
+// onError with the error. If onError returns true, ReadMultipleLenient
+// skips the row and continues; otherwise it stops and returns the error.
+// Rows read successfully still go to consumer.
+func ReadMultipleLenient[T any](
+	tx *sql.Tx,
+	row RowsForReading[T],
+	consumer consume2.Consumer[T],
+	onError func(err error) bool,
+	sql string,
+	params ...interface{}) error {
+	dbrows, err := tx.Query(sql, params...)
+	if err != nil {
+		return err
+	}
+	defer dbrows.Close()
+	if err := readRows(
+		context.Background(), row, dbrows, consumer, false, onError); err != nil {
+		return err
+	}
+	return dbrows.Err()
+}
+
+// ReadMultipleNamed works like ReadMultiple except that params provides
+// the values for named place holders such as :name in sql.
+func ReadMultipleNamed[T any](
+	tx *sql.Tx,
+	row RowsForReading[T],
+	consumer consume2.Consumer[T],
+	sql string,
+	params map[string]interface{}) error {
+	return ReadMultiple(tx, row, consumer, sql, namedArgs(params)...)
+}
+
+// ReadMultipleContext works like ReadMultiple except that it runs sql
+// within ctx. If ctx is canceled or times out while rows are being read,
+// ReadMultipleContext stops and returns ctx.Err(). ReadMultipleContext
+// checks ctx only between rows. Once there are no more rows, it returns
+// whatever error ended the rows without checking ctx again.
+func ReadMultipleContext[T any](
+	ctx context.Context,
+	tx *sql.Tx,
+	row RowsForReading[T],
+	consumer consume2.Consumer[T],
+	sql string,
+	params ...interface{}) error {
+	dbrows, err := tx.QueryContext(ctx, sql, params...)
+	if err != nil {
+		return err
+	}
+	defer dbrows.Close()
+	if err := readRows(ctx, row, dbrows, consumer, false, nil); err != nil {
 		return err
 	}
 	return dbrows.Err()
@@ -188,7 +368,8 @@ func ReadMultipleWithEtag[T any](
 		return err
 	}
 	defer dbrows.Close()
-	if err := readRows[T](row, dbrows, consumer, true); err != nil {
+	if err := readRows[T](
+		context.Background(), row, dbrows, consumer, true, nil); err != nil {
 		return err
 	}
 	return dbrows.Err()
@@ -214,16 +395,207 @@ func AddRow(
 	return err
 }
 
+// AddRowNamed works like AddRow except that sql uses named place holders
+// such as :name. names are the place holder names of the values that
+// row.Values() returns with the name for the Id column last. AddRowNamed
+// returns an error if there are fewer names than values.
+func AddRowNamed(
+	tx *sql.Tx,
+	row RowForWriting,
+	names []string,
+	rowId *int64,
+	sql string) error {
+	values, err := InsertValues(row)
+	if err != nil {
+		return err
+	}
+	args, err := toNamedArgs(names, values)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(sql, args...)
+	if err != nil {
+		return err
+	}
+	*rowId, err = result.LastInsertId()
+	return err
+}
+
+// AddRows adds the business object of each row in rows as a new row in
+// database. AddRows prepares sql once and executes it for each row.
+// The rows being added must have auto increment id field.
+// AddRows stops at the first error.
+func AddRows(
+	tx *sql.Tx,
+	rows []RowForWriting,
+	sql string) error {
+	return addRows(tx, rows, nil, sql)
+}
+
+// AddRowsWithIds works like AddRows except that it also stores the id of
+// each new row in the corresponding element of rowIds. AddRowsWithIds
+// returns an error without adding any rows if rowIds and rows have
+// different lengths.
+func AddRowsWithIds(
+	tx *sql.Tx,
+	rows []RowForWriting,
+	rowIds []int64,
+	sql string) error {
+	if len(rowIds) != len(rows) {
+		return fmt.Errorf(
+			"sqlite3_rw: %d rowIds for %d rows", len(rowIds), len(rows))
+	}
+	return addRows(tx, rows, rowIds, sql)
+}
+
+func addRows(
+	tx *sql.Tx,
+	rows []RowForWriting,
+	rowIds []int64,
+	sql string) error {
+	stmt, err := tx.Prepare(sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, row := range rows {
+		values, err := InsertValues(row)
+		if err != nil {
+			return err
+		}
+		result, err := stmt.Exec(values...)
+		if err != nil {
+			return err
+		}
+		if rowIds != nil {
+			if rowIds[i], err = result.LastInsertId(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // UpdateRow updates a row's business object in the database.
 func UpdateRow(
 	tx *sql.Tx,
 	row RowForWriting,
 	sql string) error {
+	_, err := UpdateRowCount(tx, row, sql)
+	return err
+}
+
+// UpdateRowCount works like UpdateRow except that it returns the number
+// of rows updated. Callers can use a count of 0 to detect that there
+// was no row to update.
+func UpdateRowCount(
+	tx *sql.Tx,
+	row RowForWriting,
+	sql string) (int64, error) {
+	values, err := UpdateValues(row)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(sql, values...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UpdateRowNamed works like UpdateRow except that sql uses named place
+// holders such as :name. names are the place holder names of the values
+// that row.Values() returns with the name for the Id column last.
+// UpdateRowNamed returns an error if there are fewer names than values.
+func UpdateRowNamed(
+	tx *sql.Tx,
+	row RowForWriting,
+	names []string,
+	sql string) error {
 	values, err := UpdateValues(row)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(sql, values...)
+	args, err := toNamedArgs(names, values)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(sql, args...)
+	return err
+}
+
+// UpdateRowIfMatch updates a row's business object in the database only if
+// the etag of the row currently in the database matches expectedEtag.
+// UpdateRowIfMatch reads the current row into current using readSql which
+// has a single question mark (?) place holder for the id. The id comes from
+// the last value that row.Values() returns. UpdateRowIfMatch returns
+// noSuchRow if there is no current row or ErrConcurrentModification if
+// the etags do not match. updateSql is the SQL that does the update.
+func UpdateRowIfMatch(
+	tx *sql.Tx,
+	row RowForWriting,
+	current RowForReadingEtagSetter,
+	expectedEtag uint64,
+	noSuchRow error,
+	readSql string,
+	updateSql string) error {
+	values, err := UpdateValues(row)
+	if err != nil {
+		return err
+	}
+	if err := checkEtag(
+		tx,
+		current,
+		expectedEtag,
+		noSuchRow,
+		readSql,
+		values[len(values)-1]); err != nil {
+		return err
+	}
+	_, err = tx.Exec(updateSql, values...)
+	return err
+}
+
+// DeleteRow deletes the row with given id. sql has a single question
+// mark (?) place holder for the id. DeleteRow returns ErrNoSuchRow if
+// there was no row to delete.
+func DeleteRow(
+	tx *sql.Tx,
+	id interface{},
+	sql string) error {
+	result, err := tx.Exec(sql, id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNoSuchRow
+	}
+	return nil
+}
+
+// DeleteRowIfMatch deletes the row with given id only if the etag of the
+// row currently in the database matches expectedEtag. DeleteRowIfMatch
+// reads the current row into current using readSql. Both readSql and
+// deleteSql have a single question mark (?) place holder for the id.
+// DeleteRowIfMatch returns noSuchRow if there is no current row or
+// ErrConcurrentModification if the etags do not match.
+func DeleteRowIfMatch(
+	tx *sql.Tx,
+	id interface{},
+	current RowForReadingEtagSetter,
+	expectedEtag uint64,
+	noSuchRow error,
+	readSql string,
+	deleteSql string) error {
+	if err := checkEtag(
+		tx, current, expectedEtag, noSuchRow, readSql, id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(deleteSql, id)
 	return err
 }
 
@@ -246,8 +618,105 @@ func InsertValues(row RowForWriting) (
 	return valuesForUpdate[:len(valuesForUpdate)-1], nil
 }
 
+// ExpandIn expands each question mark (?) place holder in sql whose
+// corresponding arg is a slice into one place holder per slice element and
+// flattens the slice into the returned args. ExpandIn makes queries such as
+// "select * from records where id in (?)" work with a []int64 arg. An empty
+// slice expands to NULL so that "in (?)" matches no rows. ExpandIn leaves
+// []byte args alone and ignores question marks within single quoted
+// string literals. Callers pass the returned sql and args to functions
+// such as ReadMultiple.
+func ExpandIn(sql string, args ...interface{}) (string, []interface{}) {
+	var sb strings.Builder
+	result := make([]interface{}, 0, len(args))
+	argIdx := 0
+	inLiteral := false
+	for _, ch := range sql {
+		if ch == '\'' {
+			inLiteral = !inLiteral
+		}
+		if ch != '?' || inLiteral || argIdx >= len(args) {
+			sb.WriteRune(ch)
+			continue
+		}
+		arg := args[argIdx]
+		argIdx++
+		v := reflect.ValueOf(arg)
+		if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+			sb.WriteRune(ch)
+			result = append(result, arg)
+			continue
+		}
+		if v.Len() == 0 {
+			sb.WriteString("NULL")
+			continue
+		}
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteRune('?')
+			result = append(result, v.Index(i).Interface())
+		}
+	}
+	return sb.String(), append(result, args[argIdx:]...)
+}
+
+func namedArgs(params map[string]interface{}) []interface{} {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]interface{}, len(names))
+	for i, name := range names {
+		result[i] = sql.Named(name, params[name])
+	}
+	return result
+}
+
+func toNamedArgs(
+	names []string, values []interface{}) ([]interface{}, error) {
+	if len(names) < len(values) {
+		return nil, fmt.Errorf(
+			"sqlite3_rw: %d names for %d values", len(names), len(values))
+	}
+	result := make([]interface{}, len(values))
+	for i := range values {
+		result[i] = sql.Named(names[i], values[i])
+	}
+	return result, nil
+}
+
+func checkEtag(
+	tx *sql.Tx,
+	current RowForReadingEtagSetter,
+	expectedEtag uint64,
+	noSuchRow error,
+	readSql string,
+	id interface{}) error {
+	capture := &etagCapture{RowForReadingEtagSetter: current}
+	if err := ReadSingle(tx, capture, noSuchRow, readSql, id); err != nil {
+		return err
+	}
+	if capture.etag != expectedEtag {
+		return ErrConcurrentModification
+	}
+	return nil
+}
+
+type etagCapture struct {
+	RowForReadingEtagSetter
+	etag uint64
+}
+
+func (e *etagCapture) SetEtag(etag uint64) {
+	e.RowForReadingEtagSetter.SetEtag(etag)
+	e.etag = etag
+}
+
 func doEtag(row EtagSetter) error {
-	etag, err := computeEtag(row.Values())
+	etag, err := ComputeEtag(row.Values())
 	if err != nil {
 		return err
 	}
@@ -255,7 +724,10 @@ func doEtag(row EtagSetter) error {
 	return nil
 }
 
-func computeEtag(values interface{}) (uint64, error) {
+// ComputeEtag computes the etag of a business object from its column
+// values. ComputeEtag produces the same etags as ReadSingle and
+// ReadMultipleWithEtag for the same values.
+func ComputeEtag(values []interface{}) (uint64, error) {
 	h := fnv.New64a()
 	s := fmt.Sprintf("%v", values)
 	_, err := h.Write(([]byte)(s))
@@ -1,8 +1,10 @@
 package sqlite3_rw_test
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/keep94/consume2"
@@ -210,18 +212,693 @@ func TestDatabase(t *testing.T) {
 	}))
 }
 
+func TestAddRows(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	recs := []Record{
+		{Name: "a", Phone: "1"},
+		{Name: "b", Phone: "2"},
+		{Name: "c", Phone: "3"},
+	}
+	rows := make([]sqlite3_rw.RowForWriting, len(recs))
+	for i := range recs {
+		rows[i] = (&rawRecord{}).init(&recs[i])
+	}
+	ids := make([]int64, len(recs))
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRowsWithIds(
+			tx,
+			rows,
+			ids,
+			"insert into records (name, phone) values (?, ?)",
+		)
+	}))
+	assert.Equal([]int64{1, 2, 3}, ids)
+
+	var records []Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultiple[Record](
+			tx,
+			(&rawRecord{}).init(&Record{}),
+			consume2.AppendTo(&records),
+			"select id, name, phone from records order by id asc",
+		)
+	}))
+	assert.Equal(
+		[]Record{
+			{Id: 1, Name: "a", Phone: "1"},
+			{Id: 2, Name: "b", Phone: "2"},
+			{Id: 3, Name: "c", Phone: "3"},
+		},
+		records)
+}
+
+func TestAddRowsError(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	rec1 := Record{Name: "a", Phone: "1"}
+	rec2 := Record{Name: "b", Phone: "2"}
+	rows := []sqlite3_rw.RowForWriting{
+		(&rawRecord{}).init(&rec1),
+		(&errorRecord{}).init(&rec2),
+	}
+	assert.Error(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRows(
+			tx,
+			rows,
+			"insert into records (name, phone) values (?, ?)",
+		)
+	}))
+}
+
+func TestAddRowsWithIdsMismatch(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	rows := []sqlite3_rw.RowForWriting{
+		(&rawRecord{}).init(&Record{Name: "a", Phone: "1"}),
+		(&rawRecord{}).init(&Record{Name: "b", Phone: "2"}),
+	}
+	assert.Error(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRowsWithIds(
+			tx,
+			rows,
+			make([]int64, 1),
+			"insert into records (name, phone) values (?, ?)",
+		)
+	}))
+	var count int
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return tx.QueryRow("select count(*) from records").Scan(&count)
+	}))
+	assert.Equal(0, count)
+}
+
+func TestUpdateRowIfMatch(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	noSuchId := errors.New("No such id")
+	rec := Record{Name: "a", Phone: "1"}
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRow(
+			tx,
+			(&rawRecord{}).init(&rec),
+			&rec.Id,
+			"insert into records (name, phone) values (?, ?)",
+		)
+	}))
+	var original Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawRecordWithEtag{}).init(&original),
+			noSuchId,
+			"select id, name, phone from records where id = ?",
+			rec.Id,
+		)
+	}))
+	etag := original.Etag
+
+	update := original
+	update.Phone = "2"
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.UpdateRowIfMatch(
+			tx,
+			(&rawRecord{}).init(&update),
+			(&rawRecordWithEtag{}).init(&Record{}),
+			etag,
+			noSuchId,
+			"select id, name, phone from records where id = ?",
+			"update records set name = ?, phone = ? where id = ?",
+		)
+	}))
+
+	// etag is now stale because of the last update
+	update.Phone = "3"
+	assert.Equal(
+		sqlite3_rw.ErrConcurrentModification,
+		db.Do(func(tx *sql.Tx) error {
+			return sqlite3_rw.UpdateRowIfMatch(
+				tx,
+				(&rawRecord{}).init(&update),
+				(&rawRecordWithEtag{}).init(&Record{}),
+				etag,
+				noSuchId,
+				"select id, name, phone from records where id = ?",
+				"update records set name = ?, phone = ? where id = ?",
+			)
+		}))
+
+	var current Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawRecordWithEtag{}).init(&current),
+			noSuchId,
+			"select id, name, phone from records where id = ?",
+			rec.Id,
+		)
+	}))
+	assert.Equal("2", current.Phone)
+
+	missing := Record{Id: 99, Name: "z", Phone: "9"}
+	assert.Equal(
+		noSuchId,
+		db.Do(func(tx *sql.Tx) error {
+			return sqlite3_rw.UpdateRowIfMatch(
+				tx,
+				(&rawRecord{}).init(&missing),
+				(&rawRecordWithEtag{}).init(&Record{}),
+				current.Etag,
+				noSuchId,
+				"select id, name, phone from records where id = ?",
+				"update records set name = ?, phone = ? where id = ?",
+			)
+		}))
+}
+
+func TestComputeEtag(t *testing.T) {
+	assert := assert.New(t)
+	etag1, err := sqlite3_rw.ComputeEtag([]interface{}{"a", "1", int64(3)})
+	assert.Nil(err)
+	etag2, err := sqlite3_rw.ComputeEtag([]interface{}{"a", "1", int64(3)})
+	assert.Nil(err)
+	etag3, err := sqlite3_rw.ComputeEtag([]interface{}{"a", "2", int64(3)})
+	assert.Nil(err)
+	assert.Equal(etag1, etag2)
+	assert.NotEqual(etag1, etag3)
+
+	// ComputeEtag must agree with etags the read functions compute.
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	rec := Record{Name: "a", Phone: "1"}
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRow(
+			tx,
+			(&rawRecord{}).init(&rec),
+			&rec.Id,
+			"insert into records (name, phone) values (?, ?)",
+		)
+	}))
+	var read Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawRecordWithEtag{}).init(&read),
+			errors.New("No such id"),
+			"select id, name, phone from records where id = ?",
+			rec.Id,
+		)
+	}))
+	expected, err := sqlite3_rw.ComputeEtag(
+		(&rawRecord{}).init(&read).Values())
+	assert.Nil(err)
+	assert.Equal(expected, read.Etag)
+}
+
+func TestReadMultipleContextCancel(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	consumer := &cancelingConsumer{cancel: cancel}
+	assert.Equal(
+		context.Canceled,
+		db.Do(func(tx *sql.Tx) error {
+			return sqlite3_rw.ReadMultipleContext[Record](
+				ctx,
+				tx,
+				(&rawRecord{}).init(&Record{}),
+				consumer,
+				"select id, name, phone from records order by id asc",
+			)
+		}))
+	assert.Len(consumer.records, 1)
+	assert.Equal(int64(1), consumer.records[0].Id)
+}
+
+func TestReadMultipleContext(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	var records []Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultipleContext[Record](
+			context.Background(),
+			tx,
+			(&rawRecord{}).init(&Record{}),
+			consume2.AppendTo(&records),
+			"select id, name, phone from records where id > ? order by id asc",
+			1,
+		)
+	}))
+	assert.Len(records, 2)
+}
+
+func TestNamedParameters(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	names := []string{"name", "phone", "id"}
+	rec := Record{Name: "d", Phone: "4"}
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRowNamed(
+			tx,
+			(&rawRecord{}).init(&rec),
+			names,
+			&rec.Id,
+			"insert into records (name, phone) values (:name, :phone)",
+		)
+	}))
+	assert.Nil(db.Do(addThreeRecords))
+
+	rec.Phone = "44"
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.UpdateRowNamed(
+			tx,
+			(&rawRecord{}).init(&rec),
+			names,
+			"update records set phone = :phone, name = :name where id = :id",
+		)
+	}))
+
+	var records []Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultipleNamed[Record](
+			tx,
+			(&rawRecord{}).init(&Record{}),
+			consume2.AppendTo(&records),
+			"select id, name, phone from records where id >= :low and id <= :high order by id asc",
+			map[string]interface{}{"low": 1, "high": 2},
+		)
+	}))
+	assert.Equal(
+		[]Record{
+			{Id: 1, Name: "d", Phone: "44"},
+			{Id: 2, Name: "a", Phone: "1"},
+		},
+		records)
+
+	var single Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingleNamed(
+			tx,
+			(&rawRecord{}).init(&single),
+			errors.New("No such id"),
+			"select id, name, phone from records where name = :name",
+			map[string]interface{}{"name": "c"},
+		)
+	}))
+	assert.Equal(Record{Id: 4, Name: "c", Phone: "3"}, single)
+}
+
+func TestNamedParametersTooFewNames(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	rec := Record{Name: "d", Phone: "4"}
+	assert.Error(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.AddRowNamed(
+			tx,
+			(&rawRecord{}).init(&rec),
+			[]string{"name"},
+			&rec.Id,
+			"insert into records (name, phone) values (:name, :phone)",
+		)
+	}))
+	assert.Error(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.UpdateRowNamed(
+			tx,
+			(&rawRecord{}).init(&rec),
+			[]string{"name", "phone"},
+			"update records set phone = :phone, name = :name where id = :id",
+		)
+	}))
+}
+
+func TestUpdateRowCount(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	var count int64
+	rec := Record{Id: 2, Name: "b", Phone: "22"}
+	assert.Nil(db.Do(func(tx *sql.Tx) (err error) {
+		count, err = sqlite3_rw.UpdateRowCount(
+			tx,
+			(&rawRecord{}).init(&rec),
+			"update records set name = ?, phone = ? where id = ?",
+		)
+		return
+	}))
+	assert.Equal(int64(1), count)
+
+	rec = Record{Id: 7, Name: "g", Phone: "7"}
+	assert.Nil(db.Do(func(tx *sql.Tx) (err error) {
+		count, err = sqlite3_rw.UpdateRowCount(
+			tx,
+			(&rawRecord{}).init(&rec),
+			"update records set name = ?, phone = ? where id = ?",
+		)
+		return
+	}))
+	assert.Equal(int64(0), count)
+}
+
+func TestDeleteRow(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.DeleteRow(tx, 2, "delete from records where id = ?")
+	}))
+	assert.Equal(
+		sqlite3_rw.ErrNoSuchRow,
+		db.Do(func(tx *sql.Tx) error {
+			return sqlite3_rw.DeleteRow(
+				tx, 2, "delete from records where id = ?")
+		}))
+	var count int64
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadScalar(
+			tx, &count, "select count(*) from records")
+	}))
+	assert.Equal(int64(2), count)
+}
+
+func TestDeleteRowIfMatch(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	noSuchId := errors.New("No such id")
+	var original Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadSingle(
+			tx,
+			(&rawRecordWithEtag{}).init(&original),
+			noSuchId,
+			"select id, name, phone from records where id = ?",
+			1,
+		)
+	}))
+	update := original
+	update.Phone = "11"
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.UpdateRow(
+			tx,
+			(&rawRecord{}).init(&update),
+			"update records set name = ?, phone = ? where id = ?",
+		)
+	}))
+
+	deleteIfMatch := func(id int64, etag uint64) error {
+		return db.Do(func(tx *sql.Tx) error {
+			return sqlite3_rw.DeleteRowIfMatch(
+				tx,
+				id,
+				(&rawRecordWithEtag{}).init(&Record{}),
+				etag,
+				noSuchId,
+				"select id, name, phone from records where id = ?",
+				"delete from records where id = ?",
+			)
+		})
+	}
+
+	// etag is stale because of the update
+	assert.Equal(
+		sqlite3_rw.ErrConcurrentModification,
+		deleteIfMatch(1, original.Etag))
+	etag, err := sqlite3_rw.ComputeEtag(
+		[]interface{}{update.Name, update.Phone, update.Id})
+	assert.Nil(err)
+	assert.Nil(deleteIfMatch(1, etag))
+	assert.Equal(noSuchId, deleteIfMatch(1, etag))
+}
+
+func TestExpandIn(t *testing.T) {
+	assert := assert.New(t)
+	sql, args := sqlite3_rw.ExpandIn(
+		"select * from records where name = ? and id in (?) and phone != '?'",
+		"a",
+		[]int64{1, 2, 3})
+	assert.Equal(
+		"select * from records where name = ? and id in (?, ?, ?) and phone != '?'",
+		sql)
+	assert.Equal([]interface{}{"a", int64(1), int64(2), int64(3)}, args)
+
+	sql, args = sqlite3_rw.ExpandIn(
+		"select * from records where id in (?) and name = ?",
+		[]int64{},
+		"a")
+	assert.Equal("select * from records where id in (NULL) and name = ?", sql)
+	assert.Equal([]interface{}{"a"}, args)
+
+	sql, args = sqlite3_rw.ExpandIn("select ?", []byte("raw"))
+	assert.Equal("select ?", sql)
+	assert.Equal([]interface{}{[]byte("raw")}, args)
+}
+
+func TestExpandInQuery(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	var records []Record
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		query, args := sqlite3_rw.ExpandIn(
+			"select id, name, phone from records where id in (?) order by id",
+			[]int64{1, 3})
+		return sqlite3_rw.ReadInto(tx, &records, query, args...)
+	}))
+	assert.Equal(
+		[]Record{{Id: 1, Name: "a", Phone: "1"}, {Id: 3, Name: "c", Phone: "3"}},
+		records)
+	records = nil
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		query, args := sqlite3_rw.ExpandIn(
+			"select id, name, phone from records where id in (?)",
+			[]int64{})
+		return sqlite3_rw.ReadInto(tx, &records, query, args...)
+	}))
+	assert.Empty(records)
+}
+
+func TestReadScalar(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	var count int64
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadScalar(
+			tx, &count, "select count(*) from records where id > ?", 1)
+	}))
+	assert.Equal(int64(2), count)
+
+	var name string
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadScalar(
+			tx, &name, "select name from records where id = ?", 3)
+	}))
+	assert.Equal("c", name)
+
+	assert.Equal(
+		sql.ErrNoRows,
+		db.Do(func(tx *sql.Tx) error {
+			return sqlite3_rw.ReadScalar(
+				tx, &name, "select name from records where id = ?", 4)
+		}))
+}
+
+func addThreeRecords(tx *sql.Tx) error {
+	rows := []sqlite3_rw.RowForWriting{
+		(&rawRecord{}).init(&Record{Name: "a", Phone: "1"}),
+		(&rawRecord{}).init(&Record{Name: "b", Phone: "2"}),
+		(&rawRecord{}).init(&Record{Name: "c", Phone: "3"}),
+	}
+	return sqlite3_rw.AddRows(
+		tx, rows, "insert into records (name, phone) values (?, ?)")
+}
+
+type cancelingConsumer struct {
+	cancel  context.CancelFunc
+	records []Record
+}
+
+func (c *cancelingConsumer) CanConsume() bool {
+	return true
+}
+
+func (c *cancelingConsumer) Consume(r Record) {
+	c.records = append(c.records, r)
+	c.cancel()
+}
+
 func createTable(tx *sql.Tx) error {
 	_, err := tx.Exec("create table if not exists records (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, phone TEXT)")
 	return err
 }
 
 type Record struct {
-	Id    int64
-	Name  string
-	Phone string
+	Id    int64  `db:"id"`
+	Name  string `db:"name"`
+	Phone string `db:"phone"`
 	Etag  uint64
 }
 
+func TestReadInto(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	records := []Record{{Id: 99, Name: "existing"}}
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadInto(
+			tx,
+			&records,
+			"select phone, name, id from records where id > ? order by id asc",
+			1)
+	}))
+	assert.Equal(
+		[]Record{
+			{Id: 99, Name: "existing"},
+			{Id: 2, Name: "b", Phone: "2"},
+			{Id: 3, Name: "c", Phone: "3"},
+		},
+		records)
+}
+
+func TestReadIntoUnknownColumn(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	var records []Record
+	err := db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadInto(
+			tx, &records, "select id, name as nickname from records")
+	})
+	assert.EqualError(
+		err,
+		`sqlite3_rw: no field in sqlite3_rw_test.Record tagged db:"nickname"`)
+	assert.Empty(records)
+}
+
+func TestReadIntoUnexportedField(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	type unexported struct {
+		Id   int64  `db:"id"`
+		name string `db:"name"`
+	}
+	var records []unexported
+	err := db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadInto(
+			tx, &records, "select id, name from records")
+	})
+	assert.EqualError(
+		err,
+		`sqlite3_rw: field name of sqlite3_rw_test.unexported tagged db:"name" is unexported`)
+	assert.Empty(records)
+}
+
+func TestReadMultipleLenient(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	var records []Record
+	var errs []error
+	assert.Nil(db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultipleLenient[Record](
+			tx,
+			(&rejectingRecord{reject: "b"}).init(&Record{}),
+			consume2.AppendTo(&records),
+			func(err error) bool {
+				errs = append(errs, err)
+				return true
+			},
+			"select id, name, phone from records order by id asc",
+		)
+	}))
+	assert.Equal(
+		[]Record{
+			{Id: 1, Name: "a", Phone: "1"},
+			{Id: 3, Name: "c", Phone: "3"},
+		},
+		records)
+	assert.Len(errs, 1)
+	assert.EqualError(errs[0], "rejected b")
+}
+
+func TestReadMultipleLenientAbort(t *testing.T) {
+	assert := assert.New(t)
+	rawdb, _ := sql.Open("sqlite3", ":memory:")
+	defer rawdb.Close()
+	db := sqlite3_db.New(rawdb)
+	db.Do(createTable)
+	assert.Nil(db.Do(addThreeRecords))
+	var records []Record
+	err := db.Do(func(tx *sql.Tx) error {
+		return sqlite3_rw.ReadMultipleLenient[Record](
+			tx,
+			(&rejectingRecord{reject: "b"}).init(&Record{}),
+			consume2.AppendTo(&records),
+			func(err error) bool { return false },
+			"select id, name, phone from records order by id asc",
+		)
+	})
+	assert.EqualError(err, "rejected b")
+	assert.Equal([]Record{{Id: 1, Name: "a", Phone: "1"}}, records)
+}
+
 type rawRecord struct {
 	sqlite3_rw.SimpleRow
 	*Record
@@ -257,6 +934,24 @@ func (r *rawRecordWithEtag) SetEtag(etag uint64) {
 	r.Etag = etag
 }
 
+// rejectingRecord fails to unmarshall records with a particular name.
+type rejectingRecord struct {
+	rawRecord
+	reject string
+}
+
+func (r *rejectingRecord) init(bo *Record) *rejectingRecord {
+	r.rawRecord.init(bo)
+	return r
+}
+
+func (r *rejectingRecord) Unmarshall() error {
+	if r.Name == r.reject {
+		return fmt.Errorf("rejected %s", r.Name)
+	}
+	return nil
+}
+
 type errorRecord struct {
 	*Record
 }
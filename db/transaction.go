@@ -1,6 +1,10 @@
 // Package db provides general datastore support.
 package db
 
+import (
+	"context"
+)
+
 // Transaction represents a database transaction. When a nil Transaction is
 // passed to a datastore operation, it means run the operation in its own
 // transaction.
@@ -17,3 +21,11 @@ type Action func(t Transaction) error
 type Doer interface {
 	Do(action Action) error
 }
+
+// ContextDoer is a Doer that can also perform an action within a single
+// transaction bound to a context. If the context is canceled or times
+// out, the transaction is rolled back.
+type ContextDoer interface {
+	Doer
+	DoContext(ctx context.Context, action Action) error
+}
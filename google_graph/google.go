@@ -1,17 +1,54 @@
 // package google_graph provides google bar graph and pie graph.
+//
+// Google has shut down its Image Charts API, so by default the graph URLs
+// this package builds point to QuickChart which accepts the same URL
+// parameters. Set the BaseURL field of a graph to use a self hosted
+// renderer instead.
 package google_graph
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/keep94/toolbox/http_util"
+	"io"
+	"math"
+	"mime"
+	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	kGoogleAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
 )
 
+const (
+	// kMaxImageSize is the largest graph image in bytes that DataURL
+	// accepts.
+	kMaxImageSize = 1 << 20
+)
+
+var (
+	kDefaultClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+const (
+	// DefaultBaseURL is the endpoint graphs use when their BaseURL
+	// field is empty.
+	DefaultBaseURL = "https://quickchart.io/chart"
+)
+
+var (
+	// ErrNoData is returned when graph data has no data points.
+	ErrNoData = errors.New("google_graph: no graph data")
+
+	// ErrNoPalette is returned when a graph has no colors.
+	ErrNoPalette = errors.New("google_graph: empty palette")
+)
+
 // GraphData represents a dataset to be graphed.
 type GraphData interface {
 	// The number of data points.
@@ -38,6 +75,32 @@ type GraphData2D interface {
 	Value(x, y int) int64
 }
 
+// FloatGraphData represents a dataset of float64 values to be graphed.
+type FloatGraphData interface {
+	// The number of data points.
+	Len() int
+	// The title
+	Title() string
+	// The label of the 0-based idx data point.
+	Label(idx int) string
+	// The value of the 0-based idx data point.
+	Value(idx int) float64
+}
+
+// FloatGraphData2D represents a 2D dataset of float64 values to be graphed.
+type FloatGraphData2D interface {
+	// The number of X data points
+	XLen() int
+	// The number of Y data points
+	YLen() int
+	// Return 0-based label for X axis
+	XLabel(x int) string
+	// Return 0-based label for Y axis
+	YLabel(y int) string
+	// Return value at (x, y)
+	Value(x, y int) float64
+}
+
 // Grapher returns the URL for a graph of a dataset.
 type Grapher interface {
 	GraphURL(gd GraphData) *url.URL
@@ -55,6 +118,29 @@ type BarGraph struct {
 	Palette []string
 	// a value of 10^Scale is one unit on bar graph.
 	Scale int
+	// Optional: The endpoint that renders the graph. BaseURL must accept
+	// Image Charts API parameters. If omitted, DefaultBaseURL is used.
+	BaseURL string
+	// Optional: The client DataURL uses to fetch the graph image.
+	// If omitted, a client that times out after 30 seconds is used.
+	Client *http.Client
+}
+
+// Validate returns an error if this instance can't graph gd.
+func (b *BarGraph) Validate(gd GraphData) error {
+	return validate(b.Palette, gd.Len())
+}
+
+// DataURL fetches the image of the bar graph of gd from BaseURL and
+// returns it as a data URI suitable for the src attribute of an img tag.
+// Embedding the image spares the browser a request to BaseURL. DataURL
+// returns an error if BaseURL responds with something other than an
+// image or with an image larger than 1 MiB.
+func (b *BarGraph) DataURL(gd GraphData) (string, error) {
+	if err := b.Validate(gd); err != nil {
+		return "", err
+	}
+	return fetchDataURL(b.Client, b.GraphURL(gd))
 }
 
 // GraphURL returns a link to a bar graph displaying particular graph data.
@@ -74,12 +160,8 @@ func (b *BarGraph) GraphURL2D(gd GraphData2D) *url.URL {
 	labels := make([]string, xlength)
 	titles := make([]string, ylength)
 	values := make([][]int64, ylength)
-	var includeChdl bool
 	for y := range values {
 		titles[y] = gd.YLabel(y)
-		if titles[y] != "" {
-			includeChdl = true
-		}
 		values[y] = make([]int64, xlength)
 	}
 	for x := range labels {
@@ -101,23 +183,77 @@ func (b *BarGraph) GraphURL2D(gd GraphData2D) *url.URL {
 	}
 
 	encoded := encodeInt64(max, values...)
-	url, _ := url.Parse("http://chart.apis.google.com/chart")
+	return b.graphURL(
+		labels, titles, encoded, fmt.Sprintf("1,0,%d", actualMax))
+}
+
+// GraphURLFloat works like GraphURL except that it graphs float64 values
+// which may be negative.
+func (b *BarGraph) GraphURLFloat(gd FloatGraphData) *url.URL {
+	return b.GraphURLFloat2D(floatTo2D{gd})
+}
+
+// GraphURLFloat2D works like GraphURL2D except that it graphs float64
+// values which may be negative. Bars for negative values extend
+// below the zero line in proportion to their value. NaN and infinite
+// values are graphed as missing values.
+func (b *BarGraph) GraphURLFloat2D(gd FloatGraphData2D) *url.URL {
+	xlength := gd.XLen()
+	ylength := gd.YLen()
+	if xlength <= 0 || ylength <= 0 {
+		return nil
+	}
+	labels := make([]string, xlength)
+	titles := make([]string, ylength)
+	values := make([][]float64, ylength)
+	for y := range values {
+		titles[y] = gd.YLabel(y)
+		values[y] = make([]float64, xlength)
+	}
+	for x := range labels {
+		labels[x] = gd.XLabel(x)
+		for y := range values {
+			values[y][x] = gd.Value(x, y)
+		}
+	}
+	min, max := minMaxFloat64(values...)
+	unit := math.Pow10(b.Scale)
+	min = math.Floor(min / unit)
+	max = math.Ceil(max / unit)
+	if max == min {
+		max = min + 1
+	}
+	encoded := encodeFloat64(min*unit, max*unit, values...)
+	return b.graphURL(
+		labels,
+		titles,
+		encoded,
+		fmt.Sprintf("1,%s,%s", formatFloat(min), formatFloat(max)),
+		"chp", formatFloat((0-min)/(max-min)))
+}
+
+func (b *BarGraph) graphURL(
+	labels, titles []string,
+	encoded, axisRange string,
+	extraParams ...string) *url.URL {
 	urlParams := []string{
 		"chs", "500x250",
 		"cht", "bvg",
-		"chco", encodeColors(len(values), b.Palette, ","),
+		"chco", encodeColors(len(titles), b.Palette, ","),
 		"chd", encoded,
 		"chxt", "x,y",
 		"chbh", "a",
-		"chxr", fmt.Sprintf("1,0,%d", actualMax),
-		"chxl", fmt.Sprintf("0:|%s", strings.Join(labels, "|")),
-		"chdl", strings.Join(titles, "|")}
-	// If we aren't including chdl parameter, chop it off of end of parameter
-	// list
-	if !includeChdl {
-		urlParams = urlParams[:len(urlParams)-2]
+		"chxr", axisRange,
+		"chxl", fmt.Sprintf("0:|%s", strings.Join(labels, "|"))}
+	urlParams = append(urlParams, extraParams...)
+	// Include chdl parameter only if at least one title is non empty
+	for _, title := range titles {
+		if title != "" {
+			urlParams = append(urlParams, "chdl", strings.Join(titles, "|"))
+			break
+		}
 	}
-	return http_util.AppendParams(url, urlParams...)
+	return http_util.AppendParams(baseURL(b.BaseURL), urlParams...)
 }
 
 // PieGraph builds a link to a google pie graph.
@@ -125,6 +261,26 @@ type PieGraph struct {
 	// Palette consists of the RGB colors to use in the pie graph.
 	// e.g []String{"FF0000", "00FF00", "0000FF"}
 	Palette []string
+	// Optional: The endpoint that renders the graph. BaseURL must accept
+	// Image Charts API parameters. If omitted, DefaultBaseURL is used.
+	BaseURL string
+	// Optional: The client DataURL uses to fetch the graph image.
+	// If omitted, a client that times out after 30 seconds is used.
+	Client *http.Client
+}
+
+// Validate returns an error if this instance can't graph gd.
+func (p *PieGraph) Validate(gd GraphData) error {
+	return validate(p.Palette, gd.Len())
+}
+
+// DataURL works like the DataURL method of BarGraph except that it
+// fetches a pie graph.
+func (p *PieGraph) DataURL(gd GraphData) (string, error) {
+	if err := p.Validate(gd); err != nil {
+		return "", err
+	}
+	return fetchDataURL(p.Client, p.GraphURL(gd))
 }
 
 // GraphURL returns a link to a pie graph displaying particular graph data.
@@ -141,7 +297,7 @@ func (p *PieGraph) GraphURL(gd GraphData) *url.URL {
 		values[idx] = gd.Value(idx)
 	}
 	encoded := encodeInt64(maxInt64(values), values)
-	url, _ := url.Parse("http://chart.apis.google.com/chart")
+	url := baseURL(p.BaseURL)
 	return http_util.AppendParams(
 		url,
 		"chs", "500x250",
@@ -151,6 +307,60 @@ func (p *PieGraph) GraphURL(gd GraphData) *url.URL {
 		"chdl", strings.Join(labels, "|"))
 }
 
+// baseURL parses rawURL panicking if it is malformed. If rawURL is empty,
+// baseURL returns DefaultBaseURL.
+func baseURL(rawURL string) *url.URL {
+	if rawURL == "" {
+		rawURL = DefaultBaseURL
+	}
+	result, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func validate(palette []string, length int) error {
+	if length <= 0 {
+		return ErrNoData
+	}
+	if len(palette) == 0 {
+		return ErrNoPalette
+	}
+	return nil
+}
+
+// fetchDataURL fetches the image at u and returns it as a base64 data URI.
+func fetchDataURL(client *http.Client, u *url.URL) (string, error) {
+	if client == nil {
+		client = kDefaultClient
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("google_graph: fetching graph: %s", resp.Status)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf(
+			"google_graph: graph is not an image: %q",
+			resp.Header.Get("Content-Type"))
+	}
+	image, err := io.ReadAll(io.LimitReader(resp.Body, kMaxImageSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(image) > kMaxImageSize {
+		return "", fmt.Errorf(
+			"google_graph: graph image exceeds %d bytes", kMaxImageSize)
+	}
+	return "data:" + mediaType + ";base64," +
+		base64.StdEncoding.EncodeToString(image), nil
+}
+
 type to2D struct {
 	GraphData
 }
@@ -175,6 +385,30 @@ func (t to2D) Value(x, y int) int64 {
 	return t.GraphData.Value(x)
 }
 
+type floatTo2D struct {
+	FloatGraphData
+}
+
+func (t floatTo2D) XLen() int {
+	return t.Len()
+}
+
+func (t floatTo2D) YLen() int {
+	return 1
+}
+
+func (t floatTo2D) XLabel(x int) string {
+	return t.Label(x)
+}
+
+func (t floatTo2D) YLabel(x int) string {
+	return t.Title()
+}
+
+func (t floatTo2D) Value(x, y int) float64 {
+	return t.FloatGraphData.Value(x)
+}
+
 func encodeInt64(max int64, datasets ...[]int64) string {
 	encoded := make([]string, len(datasets))
 	for idx := range datasets {
@@ -198,6 +432,39 @@ func scaleInt64For61(amount, max int64) int64 {
 	return (amount*61 + max/2) / max
 }
 
+// encodeFloat64 encodes datasets mapping min to the first letter of the
+// alphabet and max to the 61st. encodeFloat64 encodes NaN and infinite
+// values as '_' which means a missing value.
+func encodeFloat64(min, max float64, datasets ...[]float64) string {
+	encoded := make([]string, len(datasets))
+	for idx := range datasets {
+		buffer := make([]byte, len(datasets[idx]))
+		for i, v := range datasets[idx] {
+			if !isFinite(v) {
+				buffer[i] = '_'
+				continue
+			}
+			buffer[i] = kGoogleAlphabet[scaleFloat64For61(v, min, max)]
+		}
+		encoded[idx] = string(buffer)
+	}
+	return fmt.Sprintf("s:%s", strings.Join(encoded, ","))
+}
+
+func scaleFloat64For61(amount, min, max float64) int {
+	if amount <= min {
+		return 0
+	}
+	if amount >= max {
+		return 61
+	}
+	return int(math.Round((amount - min) * 61 / (max - min)))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
 func encodeColors(count int, palette []string, separator string) string {
 	colors := make([]string, count)
 	plen := len(palette)
@@ -218,3 +485,25 @@ func maxInt64(data ...[]int64) int64 {
 	}
 	return result
 }
+
+// minMaxFloat64 ignores NaN and infinite values.
+func minMaxFloat64(data ...[]float64) (min, max float64) {
+	for _, v1 := range data {
+		for _, v2 := range v1 {
+			if !isFinite(v2) {
+				continue
+			}
+			if v2 < min {
+				min = v2
+			}
+			if v2 > max {
+				max = v2
+			}
+		}
+	}
+	return
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
@@ -1,8 +1,13 @@
 package google_graph
 
 import (
+	"encoding/base64"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -52,11 +57,68 @@ func TestNoBarGraph(t *testing.T) {
 func TestBarGraph(t *testing.T) {
 	bg := BarGraph{Palette: []string{"FF0000", "00FF00"}}
 	gd := withTitle{graphData2D{{"a", 30, 50}, {"b", 75, -4}, {"c", 50, 20}}}
-	expected, _ := url.Parse("http://chart.apis.google.com/chart?chs=500x250&cht=bvg&chco=FF0000%2C00FF00&chd=s:Y9p,pAQ&chxl=0:|a|b|c&chxt=x,y&chxr=1,0,75&chbh=a&chdl=Income%7CExpense")
+	expected, _ := url.Parse("https://quickchart.io/chart?chs=500x250&cht=bvg&chco=FF0000%2C00FF00&chd=s:Y9p,pAQ&chxl=0:|a|b|c&chxt=x,y&chxr=1,0,75&chbh=a&chdl=Income%7CExpense")
 	actual := bg.GraphURL2D(gd)
 	verifyUrl(t, expected, actual)
 }
 
+func TestBarGraphBaseURL(t *testing.T) {
+	bg := BarGraph{
+		Palette: []string{"FF0000"},
+		BaseURL: "http://charts.example.com/render"}
+	gd := graphData{{"a", 30}, {"b", 60}}
+	expected, _ := url.Parse("http://charts.example.com/render?chs=500x250&cht=bvg&chco=FF0000&chd=s:f9&chxl=0:|a|b&chxt=x,y&chxr=1,0,60&chbh=a")
+	actual := bg.GraphURL(gd)
+	verifyUrl(t, expected, actual)
+}
+
+func TestBarGraphFloat(t *testing.T) {
+	bg := BarGraph{Palette: []string{"FF0000"}}
+	gd := floatGraphData{{"a", -50}, {"b", 100}, {"c", 25}}
+	expected, _ := url.Parse("https://quickchart.io/chart?chs=500x250&cht=bvg&chco=FF0000&chd=s:A9f&chxl=0:|a|b|c&chxt=x,y&chxr=1,-50,100&chbh=a&chp=0.3333333333333333")
+	actual := bg.GraphURLFloat(gd)
+	verifyUrl(t, expected, actual)
+}
+
+func TestBarGraphFloat2DScale(t *testing.T) {
+	bg := BarGraph{Palette: []string{"1", "2"}, Scale: 1}
+	gd := floatGraphData2D{{"a", -12.5, 4}, {"b", 30, -3}}
+	query := bg.GraphURLFloat2D(gd).Query()
+	verify(t, "s:J9,dV", query.Get("chd"))
+	verify(t, "1,-2,3", query.Get("chxr"))
+	verify(t, "0.4", query.Get("chp"))
+}
+
+func TestBarGraphFloatZero(t *testing.T) {
+	bg := BarGraph{Palette: []string{"1"}}
+	gd := floatGraphData{{"a", 0}, {"b", 0}}
+	query := bg.GraphURLFloat(gd).Query()
+	verify(t, "s:AA", query.Get("chd"))
+	verify(t, "1,0,1", query.Get("chxr"))
+	verify(t, "0", query.Get("chp"))
+}
+
+func TestBarGraphFloatNaNInf(t *testing.T) {
+	bg := BarGraph{Palette: []string{"1"}}
+	gd := floatGraphData{
+		{"a", math.NaN()},
+		{"b", 100},
+		{"c", math.Inf(1)},
+		{"d", math.Inf(-1)},
+		{"e", -50},
+	}
+	query := bg.GraphURLFloat(gd).Query()
+	verify(t, "s:_9__A", query.Get("chd"))
+	verify(t, "1,-50,100", query.Get("chxr"))
+}
+
+func TestNoBarGraphFloat(t *testing.T) {
+	bg := BarGraph{Palette: []string{"1"}}
+	if bg.GraphURLFloat(floatGraphData{}) != nil {
+		t.Error("Expect no graph URL for empty dataset.")
+	}
+}
+
 func TestPieGraphEncodeColors(t *testing.T) {
 	pg := PieGraph{Palette: []string{"1", "2", "3"}}
 	gd := graphData{{"a", 0}, {"b", 0}, {"c", 0}, {"d", 0}}
@@ -80,7 +142,17 @@ func TestPieGraphEncodeData(t *testing.T) {
 func TestPieGraph(t *testing.T) {
 	data := graphData{{"a", 10}, {"b", 15}, {"c", -5}}
 	pg := PieGraph{Palette: []string{"FF0000", "00FF00"}}
-	expected, _ := url.Parse("http://chart.apis.google.com/chart?chs=500x250&cht=p3&chco=FF0000%7C00FF00%7CFF0000&chd=s:p9A&chdl=a%7Cb%7Cc")
+	expected, _ := url.Parse("https://quickchart.io/chart?chs=500x250&cht=p3&chco=FF0000%7C00FF00%7CFF0000&chd=s:p9A&chdl=a%7Cb%7Cc")
+	actual := pg.GraphURL(data)
+	verifyUrl(t, expected, actual)
+}
+
+func TestPieGraphBaseURL(t *testing.T) {
+	data := graphData{{"a", 10}, {"b", 15}, {"c", -5}}
+	pg := PieGraph{
+		Palette: []string{"FF0000", "00FF00"},
+		BaseURL: "http://localhost:8080/chart"}
+	expected, _ := url.Parse("http://localhost:8080/chart?chs=500x250&cht=p3&chco=FF0000%7C00FF00%7CFF0000&chd=s:p9A&chdl=a%7Cb%7Cc")
 	actual := pg.GraphURL(data)
 	verifyUrl(t, expected, actual)
 }
@@ -93,6 +165,98 @@ func TestPieGraphEmptyDataset(t *testing.T) {
 	}
 }
 
+func TestValidate(t *testing.T) {
+	bg := BarGraph{Palette: []string{"FF0000"}}
+	verifyError(t, nil, bg.Validate(graphData{{"a", 1}}))
+	verifyError(t, ErrNoData, bg.Validate(graphData{}))
+	verifyError(t, ErrNoPalette, (&BarGraph{}).Validate(graphData{{"a", 1}}))
+	pg := PieGraph{Palette: []string{"FF0000"}}
+	verifyError(t, nil, pg.Validate(graphData{{"a", 1}}))
+	verifyError(t, ErrNoData, pg.Validate(graphData{}))
+	verifyError(t, ErrNoPalette, (&PieGraph{}).Validate(graphData{{"a", 1}}))
+}
+
+func TestDataURL(t *testing.T) {
+	png := []byte("\x89PNG fake image")
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		}))
+	defer server.Close()
+	pg := PieGraph{
+		Palette: []string{"FF0000", "00FF00"},
+		BaseURL: server.URL + "/chart",
+		Client:  server.Client()}
+	dataURL, err := pg.DataURL(graphData{{"a", 10}, {"b", 15}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(t, "p3", query.Get("cht"))
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(dataURL, prefix) {
+		t.Fatalf("Expected data URI, got %s", dataURL)
+	}
+	payload, err := base64.StdEncoding.DecodeString(dataURL[len(prefix):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(t, string(png), string(payload))
+
+	bg := BarGraph{
+		Palette: []string{"FF0000"},
+		BaseURL: server.URL + "/chart",
+		Client:  server.Client()}
+	dataURL, err = bg.DataURL(graphData{{"a", 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(t, "bvg", query.Get("cht"))
+	if !strings.HasPrefix(dataURL, prefix) {
+		t.Errorf("Expected data URI, got %s", dataURL)
+	}
+	if _, err := bg.DataURL(graphData{}); err != ErrNoData {
+		t.Errorf("Expected ErrNoData, got %v", err)
+	}
+}
+
+func TestDataURLNotImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		}))
+	defer server.Close()
+	bg := BarGraph{
+		Palette: []string{"FF0000"},
+		BaseURL: server.URL,
+		Client:  server.Client()}
+	if _, err := bg.DataURL(graphData{{"a", 10}}); err == nil {
+		t.Error("Expected error for non image response")
+	}
+	server.Config.Handler = http.NotFoundHandler()
+	if _, err := bg.DataURL(graphData{{"a", 10}}); err == nil {
+		t.Error("Expected error for not found response")
+	}
+	server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, kMaxImageSize+1))
+		})
+	if _, err := bg.DataURL(graphData{{"a", 10}}); err == nil {
+		t.Error("Expected error for oversized image")
+	}
+}
+
+func verifyError(t *testing.T, expected, actual error) {
+	t.Helper()
+	if expected != actual {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
 func verify(t *testing.T, expected, actual string) {
 	if expected != actual {
 		t.Errorf("Expected %s, got %s", expected, actual)
@@ -139,6 +303,37 @@ func (g graphData2D) Value(x, y int) int64 {
 	return g[x].Val1
 }
 
+type floatGraphItem struct {
+	Label string
+	Value float64
+}
+
+type floatGraphData []floatGraphItem
+
+func (g floatGraphData) Len() int              { return len(g) }
+func (g floatGraphData) Label(idx int) string  { return g[idx].Label }
+func (g floatGraphData) Title() string         { return "" }
+func (g floatGraphData) Value(idx int) float64 { return g[idx].Value }
+
+type floatGraphItem2D struct {
+	Label string
+	Val1  float64
+	Val2  float64
+}
+
+type floatGraphData2D []floatGraphItem2D
+
+func (g floatGraphData2D) XLen() int             { return len(g) }
+func (g floatGraphData2D) YLen() int             { return 2 }
+func (g floatGraphData2D) XLabel(idx int) string { return g[idx].Label }
+func (g floatGraphData2D) YLabel(idx int) string { return "" }
+func (g floatGraphData2D) Value(x, y int) float64 {
+	if y == 1 {
+		return g[x].Val2
+	}
+	return g[x].Val1
+}
+
 type withTitle struct {
 	graphData2D
 }
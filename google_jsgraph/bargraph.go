@@ -12,9 +12,13 @@ var (
 var {{.DataVar}} = google.visualization.arrayToDataTable({{.Data}});
 var {{.OptionsVar}} = {
   legend: { position: "none" },
-  bars: "vertical",
-  vAxis: {format: "decimal"},
-  colors: {{.Colors}}
+  bars: "{{.Bars}}",
+{{if .Stacked}}  isStacked: true,
+{{end}}{{if .HAxisTitle}}  hAxis: {title: {{.HAxisTitle}}},
+{{end}}  vAxis: {format: {{.VAxisFormat}}{{if .VAxisTitle}}, title: {{.VAxisTitle}}{{end}}},
+{{if .Width}}  width: {{.Width}},
+{{end}}{{if .Height}}  height: {{.Height}},
+{{end}}  colors: {{.Colors}}
 };
 var {{.ChartVar}} = new google.charts.Bar(document.getElementById("{{.Name}}"))
 {{.ChartVar}}.draw({{.DataVar}}, google.charts.Bar.convertOptions({{.OptionsVar}}))
@@ -34,6 +38,38 @@ type BarGraph struct {
 	// Palette consists of the RGB colors to use in the bar graph.
 	// e.g []String{"FF0000", "00FF00", "0000FF"}
 	Palette []string
+
+	// Optional: The width of the graph in pixels. If omitted, the graph
+	// is as wide as its containing div.
+	Width int
+
+	// Optional: The height of the graph in pixels. If omitted, the graph
+	// is as tall as its containing div.
+	Height int
+
+	// If true, bars are horizontal instead of vertical.
+	Horizontal bool
+
+	// If true, bars for the same X value are stacked instead of shown
+	// side by side.
+	Stacked bool
+
+	// Optional: The number format of the vertical axis such as "currency",
+	// "percent", or "#,##0.00". If omitted, the format is "decimal".
+	VAxisFormat string
+
+	// Optional: The title of the horizontal axis.
+	HAxisTitle string
+
+	// Optional: The title of the vertical axis.
+	VAxisTitle string
+}
+
+// Validate returns an error if the data of this graph has a negative
+// length in either dimension or if its methods panic for any x and y
+// within those lengths.
+func (b *BarGraph) Validate() error {
+	return validateGraphData(b.Data)
 }
 
 func (b *BarGraph) EmitPackages(packages map[string]struct{}) {
@@ -41,17 +77,39 @@ func (b *BarGraph) EmitPackages(packages map[string]struct{}) {
 }
 
 func (b *BarGraph) EmitCode(name string, sb *strings.Builder) {
+	mustCheckName(name)
 	v := &barview{
-		Data:       asJSArray(b.Data),
-		DataVar:    "data_" + name,
-		OptionsVar: "options_" + name,
-		ChartVar:   "chart_" + name,
-		Name:       name,
-		Colors:     b.paletteString(),
+		Data:        asJSArray(b.Data),
+		DataVar:     "data_" + name,
+		OptionsVar:  "options_" + name,
+		ChartVar:    "chart_" + name,
+		Name:        name,
+		Colors:      b.paletteString(),
+		Bars:        b.barsString(),
+		Stacked:     b.Stacked,
+		HAxisTitle:  optionalQuoteString(b.HAxisTitle),
+		VAxisTitle:  optionalQuoteString(b.VAxisTitle),
+		VAxisFormat: b.vAxisFormatString(),
+		Width:       b.Width,
+		Height:      b.Height,
 	}
 	http_util.WriteTextTemplate(sb, kBarGraphTemplate, v)
 }
 
+func (b *BarGraph) barsString() string {
+	if b.Horizontal {
+		return "horizontal"
+	}
+	return "vertical"
+}
+
+func (b *BarGraph) vAxisFormatString() string {
+	if b.VAxisFormat == "" {
+		return quoteString("decimal")
+	}
+	return quoteString(b.VAxisFormat)
+}
+
 func (b *BarGraph) paletteString() string {
 	parts := make([]string, 0, len(b.Palette))
 	for _, c := range b.Palette {
@@ -61,10 +119,17 @@ func (b *BarGraph) paletteString() string {
 }
 
 type barview struct {
-	Data       string
-	DataVar    string
-	OptionsVar string
-	Colors     string
-	ChartVar   string
-	Name       string
+	Data        string
+	DataVar     string
+	OptionsVar  string
+	Colors      string
+	ChartVar    string
+	Name        string
+	Bars        string
+	Stacked     bool
+	HAxisTitle  string
+	VAxisTitle  string
+	VAxisFormat string
+	Width       int
+	Height      int
 }
@@ -1,11 +1,44 @@
 package google_jsgraph
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"text/template"
 )
 
+func validateGraphData(gd GraphData) error {
+	if gd == nil {
+		return errors.New("no graph data")
+	}
+	if gd.XLen() < 0 {
+		return fmt.Errorf("XLen is negative: %d", gd.XLen())
+	}
+	if gd.YLen() < 0 {
+		return fmt.Errorf("YLen is negative: %d", gd.YLen())
+	}
+	return probe(func() {
+		dataHeading(gd)
+		for i := 0; i < gd.XLen(); i++ {
+			dataRow(gd, i)
+		}
+	})
+}
+
+// probe calls f and returns an error instead of panicking if f panics.
+// Calling the methods of a GraphData with every index that its XLen and
+// YLen promise catches data whose dimensions don't match its contents.
+func probe(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("data does not match its XLen and YLen: %v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
 func asJSArray(gd GraphData) string {
 	parts := make([]string, 0, gd.XLen()+1)
 	parts = append(parts, dataHeading(gd))
@@ -16,28 +49,51 @@ func asJSArray(gd GraphData) string {
 }
 
 func dataHeading(gd GraphData) string {
-	parts := make([]string, 0, gd.YLen()+1)
+	_, annotated := gd.(AnnotatedGraphData)
+	parts := make([]string, 0, 2*gd.YLen()+1)
 	parts = append(parts, quoteString(gd.XTitle()))
 	for i := 0; i < gd.YLen(); i++ {
 		parts = append(parts, quoteString(gd.YLabel(i)))
+		if annotated {
+			parts = append(parts, "{role: \"annotation\"}")
+		}
 	}
 	return asList(parts)
 }
 
 func dataRow(gd GraphData, row int) string {
-	parts := make([]string, 0, gd.YLen()+1)
+	agd, annotated := gd.(AnnotatedGraphData)
+	parts := make([]string, 0, 2*gd.YLen()+1)
 	parts = append(parts, quoteString(gd.XLabel(row)))
 	for i := 0; i < gd.YLen(); i++ {
 		parts = append(
 			parts, strconv.FormatFloat(gd.Value(row, i), 'g', -1, 64))
+		if annotated {
+			parts = append(parts, annotationString(agd, row, i))
+		}
 	}
 	return asList(parts)
 }
 
+func annotationString(agd AnnotatedGraphData, x, y int) string {
+	text, ok := agd.Annotation(x, y)
+	if !ok {
+		return "null"
+	}
+	return quoteString(text)
+}
+
 func quoteString(s string) string {
 	return "\"" + template.JSEscapeString(s) + "\""
 }
 
+func optionalQuoteString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return quoteString(s)
+}
+
 func asList(parts []string) string {
 	return "[" + strings.Join(parts, ", ") + "]"
 }
@@ -2,6 +2,7 @@
 package google_jsgraph
 
 import (
+	"fmt"
 	"html/template"
 	"regexp"
 	"sort"
@@ -11,24 +12,30 @@ import (
 )
 
 var (
-	namePattern = regexp.MustCompile(`^[a-z0-9]+$`)
+	namePattern       = regexp.MustCompile(`^[a-z0-9]+$`)
+	identifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
 )
 
 var (
 	kGoogleGraphTemplateSpec = `
 <script type="text/javascript" src="https://www.gstatic.com/charts/loader.js"></script>
 <script type="text/javascript">
-  google.charts.load("current", {packages:[{{.Packages}}]});
-  google.charts.setOnLoadCallback(drawCharts);
-  function drawCharts() {
+  google.charts.load("{{.Version}}", {packages:[{{.Packages}}]});
+  google.charts.setOnLoadCallback({{.Callback}});
+  function {{.Callback}}() {
 {{.Code}}
   }
 </script>
 `
 )
 
+var (
+	kContainerTemplateSpec = `<div{{if .Class}} class="{{.Class}}"{{end}}>{{if .Title}}<h3>{{.Title}}</h3>{{end}}<div id="{{.Name}}"></div></div>`
+)
+
 var (
 	kGoogleGraphTemplate = template.Must(template.New("googleJsGraph").Parse(kGoogleGraphTemplateSpec))
+	kContainerTemplate   = template.Must(template.New("container").Parse(kContainerTemplateSpec))
 )
 
 // GraphData represents a dataset to be graphed.
@@ -53,6 +60,17 @@ type GraphData interface {
 	Value(x, y int) float64
 }
 
+// AnnotatedGraphData is a GraphData that has annotations for its data
+// points. When the data of a graph implements AnnotatedGraphData, each Y
+// column is followed by an annotation column.
+type AnnotatedGraphData interface {
+	GraphData
+
+	// Return the annotation at (x, y). ok is false if there is no
+	// annotation at (x, y).
+	Annotation(x, y int) (text string, ok bool)
+}
+
 // Graph represents a Google javascript graph
 type Graph interface {
 
@@ -60,17 +78,104 @@ type Graph interface {
 	EmitPackages(packages map[string]struct{})
 
 	// Emits the the code within the drawCharts() function that draws this
-	// graph.
+	// graph. name is the id of the div tag where the graph goes.
+	// BarGraph and PieGraph panic if name does not match [a-z0-9]+ as
+	// they put name into javascript unescaped.
 	EmitCode(name string, sb *strings.Builder)
 }
 
+// Validator is implemented by Graphs that can check themselves before
+// being emitted. BarGraph and PieGraph implement Validator.
+type Validator interface {
+
+	// Validate returns a descriptive error if this graph can't be drawn.
+	Validate() error
+}
+
+// Option represents an optional setting for EmitWithOptions.
+type Option func(o *emitOptions)
+
+// ChartsVersion pins the version of the Google charts loader. The default
+// is "current".
+func ChartsVersion(version string) Option {
+	return func(o *emitOptions) {
+		o.version = version
+	}
+}
+
+// OnLoadCallback sets the name of the javascript function that draws the
+// graphs. The default is "drawCharts". Changing the name avoids collisions
+// when a page contains the output of more than one emit call. name must be
+// a valid javascript identifier or else EmitWithOptions panics.
+func OnLoadCallback(name string) Option {
+	return func(o *emitOptions) {
+		o.callback = name
+	}
+}
+
+// ContainerClass sets the CSS class of the div that EmitWithContainers
+// wraps around each graph. By default the wrapping div has no class.
+// Emit and EmitWithOptions ignore this option.
+func ContainerClass(class string) Option {
+	return func(o *emitOptions) {
+		o.containerClass = class
+	}
+}
+
+// ContainerTitle sets the title that EmitWithContainers shows above the
+// graph with the given name. By default graphs have no title.
+// Emit and EmitWithOptions ignore this option.
+func ContainerTitle(name, title string) Option {
+	return func(o *emitOptions) {
+		if o.containerTitles == nil {
+			o.containerTitles = make(map[string]string)
+		}
+		o.containerTitles[name] = title
+	}
+}
+
 // MustEmit emits the javascript chunk that renders the graphs.
 // In graphs, the keys are the ids of the div tags where the graphs go.
 // The keys must match [a-z0-9]+ or else MustEmit panics. The return value
 // of MustEmit belongs in the head section of the html document.
 func MustEmit(graphs map[string]Graph) template.HTML {
+	return EmitWithOptions(graphs)
+}
+
+// EmitWithOptions works like MustEmit except that options can change the
+// charts version and the name of the callback function.
+// Like MustEmit, EmitWithOptions does not call Validate on the graphs.
+func EmitWithOptions(
+	graphs map[string]Graph, options ...Option) template.HTML {
+	result, err := emit(graphs, false, options)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// Emit works like EmitWithOptions except that it returns an error instead
+// of panicking. Emit also returns an error if a graph implementing
+// Validator fails validation.
+func Emit(
+	graphs map[string]Graph, options ...Option) (template.HTML, error) {
+	return emit(graphs, true, options)
+}
+
+func emit(
+	graphs map[string]Graph, validate bool, options []Option) (
+	template.HTML, error) {
+	opts := emitOptions{version: "current", callback: "drawCharts"}
+	for _, option := range options {
+		option(&opts)
+	}
+	if !identifierPattern.MatchString(opts.callback) {
+		return "", fmt.Errorf(
+			"google_jsgraph: callback %q is not a valid javascript identifier",
+			opts.callback)
+	}
 	if len(graphs) == 0 {
-		return ""
+		return "", nil
 	}
 	names := make([]string, 0, len(graphs))
 	for n := range graphs {
@@ -78,29 +183,84 @@ func MustEmit(graphs map[string]Graph) template.HTML {
 	}
 	sort.Strings(names)
 
+	for _, name := range names {
+		if err := checkName(name); err != nil {
+			return "", err
+		}
+		if v, ok := graphs[name].(Validator); ok && validate {
+			if err := v.Validate(); err != nil {
+				return "", fmt.Errorf("google_jsgraph: %s: %w", name, err)
+			}
+		}
+	}
+
 	var code strings.Builder
 	packages := make(map[string]struct{})
 	for _, name := range names {
 		graphs[name].EmitPackages(packages)
 	}
 	for _, name := range names {
-		if !isValidName(name) {
-			panic("Names must match [a-z0-9]+")
-		}
 		graphs[name].EmitCode(name, &code)
 	}
 	v := &view{
 		Packages: packagesAsString(packages),
 		Code:     template.JS(code.String()),
+		Version:  opts.version,
+		Callback: template.JS(opts.callback),
 	}
 	var sb strings.Builder
 	http_util.WriteTemplate(&sb, kGoogleGraphTemplate, v)
-	return template.HTML(sb.String())
+	return template.HTML(sb.String()), nil
+}
+
+// EmitWithContainers works like Emit except that it also returns the
+// html for the div containers where the graphs go. containers maps each
+// graph name to its container. Each container is a div with an optional
+// class wrapping an optional h3 title and the div with the id that the
+// javascript chunk expects.
+func EmitWithContainers(
+	graphs map[string]Graph, options ...Option) (
+	script template.HTML, containers map[string]template.HTML, err error) {
+	script, err = Emit(graphs, options...)
+	if err != nil {
+		return
+	}
+	var opts emitOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	containers = make(map[string]template.HTML, len(graphs))
+	for name := range graphs {
+		v := &containerView{
+			Name:  name,
+			Class: opts.containerClass,
+			Title: opts.containerTitles[name],
+		}
+		var sb strings.Builder
+		http_util.WriteTemplate(&sb, kContainerTemplate, v)
+		containers[name] = template.HTML(sb.String())
+	}
+	return
+}
+
+type emitOptions struct {
+	version         string
+	callback        string
+	containerClass  string
+	containerTitles map[string]string
+}
+
+type containerView struct {
+	Name  string
+	Class string
+	Title string
 }
 
 type view struct {
 	Packages template.JS
 	Code     template.JS
+	Version  string
+	Callback template.JS
 }
 
 func packagesAsString(packages map[string]struct{}) template.JS {
@@ -115,6 +275,18 @@ func packagesAsString(packages map[string]struct{}) template.JS {
 	return template.JS(strings.Join(parts, ", "))
 }
 
-func isValidName(name string) bool {
-	return namePattern.MatchString(name)
+// checkName returns an error if name is not a valid graph name.
+func checkName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("google_jsgraph: name %q must match [a-z0-9]+", name)
+	}
+	return nil
+}
+
+// mustCheckName works like checkName except that it panics instead of
+// returning an error.
+func mustCheckName(name string) {
+	if err := checkName(name); err != nil {
+		panic(err)
+	}
 }
@@ -1,6 +1,7 @@
 package google_jsgraph
 
 import (
+	"html/template"
 	"strings"
 	"testing"
 
@@ -29,6 +30,49 @@ Pie graph code
 	assert.Equal(t, expected, string(chunk))
 }
 
+func TestEmitWithOptions(t *testing.T) {
+	expected := `
+<script type="text/javascript" src="https://www.gstatic.com/charts/loader.js"></script>
+<script type="text/javascript">
+  google.charts.load("51", {packages:['bar', 'baz']});
+  google.charts.setOnLoadCallback(myDraw);
+  function myDraw() {
+Bar graph code
+
+
+  }
+</script>
+`
+	chunk := EmitWithOptions(
+		map[string]Graph{"bargraph": barGraphForTesting{}},
+		ChartsVersion("51"),
+		OnLoadCallback("myDraw"))
+	assert.Equal(t, expected, string(chunk))
+}
+
+func TestEmitWithOptionsDefault(t *testing.T) {
+	graphs := map[string]Graph{
+		"bargraph": barGraphForTesting{},
+		"piegraph": pieGraphForTesting{},
+	}
+	assert.Equal(t, MustEmit(graphs), EmitWithOptions(graphs))
+}
+
+func TestEmitWithOptionsEscapesVersion(t *testing.T) {
+	chunk := EmitWithOptions(
+		map[string]Graph{"bargraph": barGraphForTesting{}},
+		ChartsVersion(`51"); alert("hi`))
+	assert.NotContains(t, string(chunk), `alert("hi`)
+}
+
+func TestEmitWithOptionsBadCallbackPanics(t *testing.T) {
+	assert.Panics(t, func() {
+		EmitWithOptions(
+			map[string]Graph{"bargraph": barGraphForTesting{}},
+			OnLoadCallback("draw(); alert"))
+	})
+}
+
 func TestMustEmitPanics(t *testing.T) {
 	assert.Panics(t, func() {
 		MustEmit(map[string]Graph{
@@ -37,10 +81,119 @@ func TestMustEmitPanics(t *testing.T) {
 	})
 }
 
+func TestEmitValidatesPieGraph(t *testing.T) {
+	assert := assert.New(t)
+	pg := &PieGraph{Data: &fakeGraphData{
+		title:   "Category",
+		xlabels: []string{"Car", "Food"},
+		ylabels: []string{"Amount", "Budget"},
+		values:  []float64{1, 2, 3, 4},
+	}}
+	_, err := Emit(map[string]Graph{"piegraph": pg})
+	assert.EqualError(
+		err, "google_jsgraph: piegraph: pie graph YLen must be 1, got 2")
+
+	// MustEmit still renders graphs that Validate rejects but that it can
+	// draw.
+	assert.NotPanics(func() {
+		MustEmit(map[string]Graph{"piegraph": pg})
+	})
+}
+
+func TestEmitValidatesMismatchedData(t *testing.T) {
+	assert := assert.New(t)
+	bg := &BarGraph{Data: &fakeGraphData{
+		title:   "Category",
+		xlabels: []string{"Car", "Food"},
+		ylabels: []string{"Amount", "Budget"},
+		values:  []float64{1, 2, 3},
+	}}
+	var err error
+	assert.NotPanics(func() {
+		_, err = Emit(map[string]Graph{"bargraph": bg})
+	})
+	assert.Error(err)
+	assert.Contains(err.Error(), "google_jsgraph: bargraph: data does not match")
+	pg := &PieGraph{Data: &fakeGraphData{
+		title:   "Category",
+		xlabels: []string{"Car", "Food"},
+		ylabels: []string{"Amount"},
+		values:  []float64{1},
+	}}
+	assert.NotPanics(func() {
+		_, err = Emit(map[string]Graph{"piegraph": pg})
+	})
+	assert.Error(err)
+}
+
+func TestEmitValidatesNegativeLength(t *testing.T) {
+	assert := assert.New(t)
+	bg := &BarGraph{Data: &negativeGraphData{}}
+	_, err := Emit(map[string]Graph{"bargraph": bg})
+	assert.EqualError(err, "google_jsgraph: bargraph: XLen is negative: -1")
+	_, err = Emit(map[string]Graph{"bargraph": &BarGraph{}})
+	assert.EqualError(err, "google_jsgraph: bargraph: no graph data")
+}
+
+func TestEmitBadName(t *testing.T) {
+	_, err := Emit(map[string]Graph{"bar_graph": barGraphForTesting{}})
+	assert.Error(t, err)
+}
+
+func TestEmitCodeRejectsBadName(t *testing.T) {
+	assert := assert.New(t)
+	badName := `x"); alert("hi`
+	bg := &BarGraph{Data: barDataForTesting()}
+	pg := &PieGraph{Data: &fakeGraphData{
+		title:   "Category",
+		xlabels: []string{"Car"},
+		ylabels: []string{"Amount"},
+		values:  []float64{1},
+	}}
+	for _, g := range []Graph{bg, pg} {
+		var sb strings.Builder
+		assert.Panics(func() { g.EmitCode(badName, &sb) })
+		assert.NotContains(sb.String(), "alert")
+	}
+}
+
 func TestMustEmitEmpty(t *testing.T) {
 	assert.Empty(t, MustEmit(nil))
 }
 
+func TestEmitWithContainers(t *testing.T) {
+	assert := assert.New(t)
+	graphs := map[string]Graph{
+		"bargraph": barGraphForTesting{},
+		"piegraph": pieGraphForTesting{},
+	}
+	script, containers, err := EmitWithContainers(
+		graphs,
+		ContainerClass("chart"),
+		ContainerTitle("bargraph", "Spending <by> month & year"))
+	assert.NoError(err)
+	assert.Equal(MustEmit(graphs), script)
+	assert.Equal(
+		map[string]template.HTML{
+			"bargraph": `<div class="chart"><h3>Spending &lt;by&gt; month &amp; year</h3><div id="bargraph"></div></div>`,
+			"piegraph": `<div class="chart"><div id="piegraph"></div></div>`,
+		},
+		containers)
+}
+
+func TestEmitWithContainersDefault(t *testing.T) {
+	assert := assert.New(t)
+	_, containers, err := EmitWithContainers(
+		map[string]Graph{"bargraph": barGraphForTesting{}})
+	assert.NoError(err)
+	assert.Equal(
+		template.HTML(`<div><div id="bargraph"></div></div>`),
+		containers["bargraph"])
+	_, _, err = EmitWithContainers(
+		map[string]Graph{"bar_graph": barGraphForTesting{}})
+	assert.Error(err)
+}
+
 func TestRealGraphs(t *testing.T) {
 	expected := `
 <script type="text/javascript" src="https://www.gstatic.com/charts/loader.js"></script>
@@ -138,6 +291,176 @@ chart_piegraph.draw(data_piegraph, options_piegraph)
 	assert.Equal(t, expected, sb.String())
 }
 
+func TestBarGraphOptions(t *testing.T) {
+	testCases := []struct {
+		horizontal bool
+		stacked    bool
+		expected   string
+	}{
+		{
+			expected: `var options_bargraph = {
+  legend: { position: "none" },
+  bars: "vertical",
+  vAxis: {format: "decimal"},
+  colors: ["#990000"]
+};`,
+		},
+		{
+			horizontal: true,
+			expected: `var options_bargraph = {
+  legend: { position: "none" },
+  bars: "horizontal",
+  vAxis: {format: "decimal"},
+  colors: ["#990000"]
+};`,
+		},
+		{
+			stacked: true,
+			expected: `var options_bargraph = {
+  legend: { position: "none" },
+  bars: "vertical",
+  isStacked: true,
+  vAxis: {format: "decimal"},
+  colors: ["#990000"]
+};`,
+		},
+		{
+			horizontal: true,
+			stacked:    true,
+			expected: `var options_bargraph = {
+  legend: { position: "none" },
+  bars: "horizontal",
+  isStacked: true,
+  vAxis: {format: "decimal"},
+  colors: ["#990000"]
+};`,
+		},
+	}
+	for _, tc := range testCases {
+		bg := &BarGraph{
+			Data:       barDataForTesting(),
+			Palette:    []string{"990000"},
+			Horizontal: tc.horizontal,
+			Stacked:    tc.stacked,
+		}
+		var sb strings.Builder
+		bg.EmitCode("bargraph", &sb)
+		assert.Contains(t, sb.String(), tc.expected)
+	}
+}
+
+func TestBarGraphAxes(t *testing.T) {
+	expected := `var options_bargraph = {
+  legend: { position: "none" },
+  bars: "vertical",
+  hAxis: {title: "Month"},
+  vAxis: {format: "currency", title: "Spending \"USD\""},
+  colors: ["#990000"]
+};`
+	bg := &BarGraph{
+		Data:        barDataForTesting(),
+		Palette:     []string{"990000"},
+		VAxisFormat: "currency",
+		HAxisTitle:  "Month",
+		VAxisTitle:  "Spending \"USD\"",
+	}
+	var sb strings.Builder
+	bg.EmitCode("bargraph", &sb)
+	assert.Contains(t, sb.String(), expected)
+}
+
+func TestBarGraphVAxisFormatOnly(t *testing.T) {
+	expected := `var options_bargraph = {
+  legend: { position: "none" },
+  bars: "vertical",
+  vAxis: {format: "percent"},
+  colors: ["#990000"]
+};`
+	bg := &BarGraph{
+		Data:        barDataForTesting(),
+		Palette:     []string{"990000"},
+		VAxisFormat: "percent",
+	}
+	var sb strings.Builder
+	bg.EmitCode("bargraph", &sb)
+	assert.Contains(t, sb.String(), expected)
+}
+
+func TestAnnotatedGraphData(t *testing.T) {
+	expected := `[
+["Month", "Amount", {role: "annotation"}],
+["Jan", 1.5, "low \"1\""],
+["Feb", 2.5, null]
+]`
+	data := &fakeAnnotatedGraphData{
+		fakeGraphData: barDataForTesting(),
+		annotations:   map[int]string{0: "low \"1\""},
+	}
+	assert.Equal(t, expected, asJSArray(data))
+}
+
+func TestPlainGraphData(t *testing.T) {
+	expected := `[
+["Month", "Amount"],
+["Jan", 1.5],
+["Feb", 2.5]
+]`
+	assert.Equal(t, expected, asJSArray(barDataForTesting()))
+}
+
+func TestBarGraphSize(t *testing.T) {
+	assert := assert.New(t)
+	expected := `var options_bargraph = {
+  legend: { position: "none" },
+  bars: "vertical",
+  vAxis: {format: "decimal"},
+  width: 600,
+  height: 400,
+  colors: ["#990000"]
+};`
+	bg := &BarGraph{
+		Data:    barDataForTesting(),
+		Palette: []string{"990000"},
+		Width:   600,
+		Height:  400,
+	}
+	var sb strings.Builder
+	bg.EmitCode("bargraph", &sb)
+	assert.Contains(sb.String(), expected)
+
+	bg = &BarGraph{Data: barDataForTesting(), Height: 300}
+	sb.Reset()
+	bg.EmitCode("bargraph", &sb)
+	assert.Contains(sb.String(), "  height: 300,\n")
+	assert.NotContains(sb.String(), "width")
+}
+
+func TestPieGraphSize(t *testing.T) {
+	assert := assert.New(t)
+	expected := `var options_piegraph = {
+  legend: "none",
+  is3D: true,
+  pieSliceText: "none",
+  width: 500,
+  slices: {
+}
+};`
+	pg := &PieGraph{Data: barDataForTesting(), Width: 500}
+	var sb strings.Builder
+	pg.EmitCode("piegraph", &sb)
+	assert.Contains(sb.String(), expected)
+	assert.NotContains(sb.String(), "height")
+}
+
+func barDataForTesting() *fakeGraphData {
+	return &fakeGraphData{
+		title:   "Month",
+		xlabels: []string{"Jan", "Feb"},
+		ylabels: []string{"Amount"},
+		values:  []float64{1.5, 2.5},
+	}
+}
+
 type fakeGraphData struct {
 	title   string
 	xlabels []string
@@ -154,6 +477,22 @@ func (f *fakeGraphData) Value(x, y int) float64 {
 	return f.values[x*f.YLen()+y]
 }
 
+type negativeGraphData struct {
+	fakeGraphData
+}
+
+func (n negativeGraphData) XLen() int { return -1 }
+
+type fakeAnnotatedGraphData struct {
+	*fakeGraphData
+	annotations map[int]string
+}
+
+func (f *fakeAnnotatedGraphData) Annotation(x, y int) (string, bool) {
+	text, ok := f.annotations[x*f.YLen()+y]
+	return text, ok
+}
+
 type barGraphForTesting struct {
 }
 
@@ -15,7 +15,9 @@ var {{.OptionsVar}} = {
   legend: "none",
   is3D: true,
   pieSliceText: "none",
-  slices: {{.Colors}}
+{{if .Width}}  width: {{.Width}},
+{{end}}{{if .Height}}  height: {{.Height}},
+{{end}}  slices: {{.Colors}}
 };
 var {{.ChartVar}} = new google.visualization.PieChart(document.getElementById("{{.Name}}"))
 {{.ChartVar}}.draw({{.DataVar}}, {{.OptionsVar}})
@@ -36,6 +38,27 @@ type PieGraph struct {
 	// e.g []String{"FF0000", "00FF00", "0000FF"}. If omitted, Google chooses
 	// the palette.
 	Palette []string
+
+	// Optional: The width of the graph in pixels. If omitted, the graph
+	// is as wide as its containing div.
+	Width int
+
+	// Optional: The height of the graph in pixels. If omitted, the graph
+	// is as tall as its containing div.
+	Height int
+}
+
+// Validate returns an error if the data of this graph has a negative
+// length in either dimension, if its methods panic for any x and y within
+// those lengths, or if its YLen is not 1.
+func (p *PieGraph) Validate() error {
+	if err := validateGraphData(p.Data); err != nil {
+		return err
+	}
+	if p.Data.YLen() != 1 {
+		return fmt.Errorf("pie graph YLen must be 1, got %d", p.Data.YLen())
+	}
+	return nil
 }
 
 func (p *PieGraph) EmitPackages(packages map[string]struct{}) {
@@ -43,12 +66,15 @@ func (p *PieGraph) EmitPackages(packages map[string]struct{}) {
 }
 
 func (p *PieGraph) EmitCode(name string, sb *strings.Builder) {
+	mustCheckName(name)
 	v := &pieview{
 		Data:       asJSArray(p.Data),
 		DataVar:    "data_" + name,
 		OptionsVar: "options_" + name,
 		ChartVar:   "chart_" + name,
 		Name:       name,
+		Width:      p.Width,
+		Height:     p.Height,
 		Colors:     p.paletteString(),
 	}
 	http_util.WriteTextTemplate(sb, kPieGraphTemplate, v)
@@ -75,4 +101,6 @@ type pieview struct {
 	Colors     string
 	ChartVar   string
 	Name       string
+	Width      int
+	Height     int
 }
@@ -0,0 +1,424 @@
+package http_util_test
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/keep94/toolbox/http_util"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	kComboBox = http_util.ComboBox{
+		{Name: "One", Value: 1},
+		{Name: "Two", Value: 2},
+		{Name: "Three", Value: 3},
+	}
+)
+
+func TestComboBoxNameForValue(t *testing.T) {
+	assert := assert.New(t)
+	name, ok := kComboBox.NameForValue(2)
+	assert.True(ok)
+	assert.Equal("Two", name)
+}
+
+func TestComboBoxNameForValueMissing(t *testing.T) {
+	assert := assert.New(t)
+	name, ok := kComboBox.NameForValue(4)
+	assert.False(ok)
+	assert.Equal("", name)
+	_, ok = kComboBox.NameForValue(int64(2))
+	assert.False(ok)
+}
+
+func TestComboBoxNameForValueNil(t *testing.T) {
+	assert := assert.New(t)
+	_, ok := kComboBox.NameForValue(nil)
+	assert.False(ok)
+	withNil := http_util.ComboBox{{Name: "None", Value: nil}}
+	name, ok := withNil.NameForValue(nil)
+	assert.True(ok)
+	assert.Equal("None", name)
+}
+
+func TestReturnTo(t *testing.T) {
+	assert := assert.New(t)
+	w := httptest.NewRecorder()
+	http_util.StoreReturnTo(
+		w, httptest.NewRequest("GET", "/account/edit?id=5&tab=a%20b", nil))
+	cookies := w.Result().Cookies()
+	assert.Len(cookies, 1)
+	assert.True(cookies[0].HttpOnly)
+	assert.Equal(http.SameSiteLaxMode, cookies[0].SameSite)
+
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	http_util.RedirectToReturnTo(w, r, "/home")
+	assert.Equal(http.StatusFound, w.Code)
+	assert.Equal("/account/edit?id=5&tab=a%20b", w.Header().Get("Location"))
+
+	// The stored URI is forgotten
+	cookies = w.Result().Cookies()
+	assert.Len(cookies, 1)
+	assert.True(cookies[0].MaxAge < 0)
+}
+
+func TestReturnToSecure(t *testing.T) {
+	w := httptest.NewRecorder()
+	http_util.StoreReturnTo(
+		w, httptest.NewRequest("GET", "https://example.com/secret", nil))
+	assert.True(t, w.Result().Cookies()[0].Secure)
+}
+
+func TestReturnToRejectsExternal(t *testing.T) {
+	assert := assert.New(t)
+	unsafe := []string{
+		"http://evil.com/login",
+		"//evil.com/login",
+		"/\\evil.com/login",
+		"javascript:alert(1)",
+		"evil.com",
+		"/ok\r\nLocation: http://evil.com",
+	}
+	for _, returnTo := range unsafe {
+		r := httptest.NewRequest("GET", "/login", nil)
+		r.AddCookie(&http.Cookie{
+			Name: "return_to", Value: url.QueryEscape(returnTo)})
+		w := httptest.NewRecorder()
+		http_util.RedirectToReturnTo(w, r, "/home")
+		assert.Equal("/home", w.Header().Get("Location"), returnTo)
+	}
+}
+
+func TestReturnToFallback(t *testing.T) {
+	assert := assert.New(t)
+	w := httptest.NewRecorder()
+	http_util.RedirectToReturnTo(
+		w, httptest.NewRequest("GET", "/login", nil), "/home")
+	assert.Equal(http.StatusFound, w.Code)
+	assert.Equal("/home", w.Header().Get("Location"))
+	assert.Empty(w.Result().Cookies())
+}
+
+type formForTesting struct {
+	Name     string  `form:"name"`
+	Age      int     `form:"age"`
+	Id       int64   `form:"id"`
+	Active   bool    `form:"active"`
+	Amount   float64 `form:"amount"`
+	Nickname string  `form:"nickname"`
+	Ignored  string
+}
+
+func TestDecodeForm(t *testing.T) {
+	assert := assert.New(t)
+	values := url.Values{
+		"name":    {"Bob"},
+		"age":     {"42"},
+		"id":      {"9007199254740993"},
+		"active":  {"on"},
+		"amount":  {"12.75"},
+		"Ignored": {"x"},
+	}
+	form := formForTesting{Nickname: "unchanged"}
+	assert.NoError(http_util.DecodeForm(values, &form))
+	assert.Equal(formForTesting{
+		Name:     "Bob",
+		Age:      42,
+		Id:       9007199254740993,
+		Active:   true,
+		Amount:   12.75,
+		Nickname: "unchanged",
+	}, form)
+}
+
+func TestDecodeFormErrors(t *testing.T) {
+	assert := assert.New(t)
+	values := url.Values{
+		"name":   {"Bob"},
+		"age":    {"forty"},
+		"active": {"maybe"},
+		"amount": {""},
+	}
+	var form formForTesting
+	err := http_util.DecodeForm(values, &form)
+	formErrors, ok := err.(http_util.FormErrors)
+	assert.True(ok)
+	assert.Len(formErrors, 2)
+	assert.Contains(formErrors, "age")
+	assert.Contains(formErrors, "active")
+	assert.Contains(err.Error(), `age: strconv.ParseInt: parsing "forty"`)
+	assert.Equal("Bob", form.Name)
+	assert.Equal(0, form.Age)
+	assert.Equal(0.0, form.Amount)
+}
+
+func TestDecodeFormUnexportedField(t *testing.T) {
+	assert := assert.New(t)
+	var form struct {
+		Name string `form:"name"`
+		age  int    `form:"age"`
+	}
+	err := http_util.DecodeForm(
+		url.Values{"name": {"Bob"}, "age": {"42"}}, &form)
+	assert.Error(err)
+	_, ok := err.(http_util.FormErrors)
+	assert.False(ok)
+	assert.Equal("", form.Name)
+}
+
+func TestDecodeFormUnsupportedField(t *testing.T) {
+	assert := assert.New(t)
+	var form struct {
+		Name string         `form:"name"`
+		Tags []string       `form:"tags"`
+		Meta map[string]int `form:"meta"`
+	}
+	err := http_util.DecodeForm(
+		url.Values{"name": {"Bob"}, "tags": {"a"}}, &form)
+	assert.Error(err)
+	_, ok := err.(http_util.FormErrors)
+	assert.False(ok)
+	assert.Equal("", form.Name)
+	assert.Error(http_util.DecodeForm(url.Values{}, &form))
+}
+
+func TestDecodeFormBadDst(t *testing.T) {
+	assert.Panics(t, func() {
+		http_util.DecodeForm(url.Values{}, formForTesting{})
+	})
+}
+
+func TestAddStaticFromFileContentType(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	cssPath := filepath.Join(dir, "style.css")
+	assert.NoError(os.WriteFile(cssPath, []byte("body { color: red; }"), 0644))
+	noExtPath := filepath.Join(dir, "icon")
+	assert.NoError(os.WriteFile(noExtPath, []byte("\x89PNG\r\n\x1a\n0000"), 0644))
+	mux := http.NewServeMux()
+	assert.NoError(http_util.AddStaticFromFile(mux, "/style", cssPath))
+	assert.NoError(http_util.AddStaticFromFile(mux, "/favicon", noExtPath))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/style", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("text/css; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal("body { color: red; }", w.Body.String())
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/favicon", nil))
+	assert.Equal("image/png", w.Header().Get("Content-Type"))
+}
+
+func TestAddStaticFromFileMissing(t *testing.T) {
+	assert.Error(t, http_util.AddStaticFromFile(
+		http.NewServeMux(), "/style", filepath.Join(t.TempDir(), "none.css")))
+}
+
+func TestPageWindowMiddle(t *testing.T) {
+	crumb := &http_util.PageBreadCrumb{
+		URL:         http_util.NewUrl("/list", "q", "x"),
+		PageNoParam: "pn",
+		PageNo:      5,
+		PageCount:   20,
+	}
+	links := crumb.PageWindow(2)
+	assert.Equal(t, "1 … 4 5 [6] 7 8 … 20", pageWindowString(links))
+	assert.Equal(t, "/list?pn=3&q=x", links[2].URL.String())
+}
+
+func TestPageWindowStart(t *testing.T) {
+	crumb := &http_util.PageBreadCrumb{
+		URL:         http_util.NewUrl("/list"),
+		PageNoParam: "pn",
+		PageNo:      1,
+		PageCount:   20,
+	}
+	assert.Equal(t, "1 [2] 3 4 … 20", pageWindowString(crumb.PageWindow(2)))
+	crumb.PageNo = 0
+	assert.Equal(t, "[1] 2 3 … 20", pageWindowString(crumb.PageWindow(2)))
+
+	// A single omitted page is shown instead of an ellipsis
+	crumb.PageNo = 4
+	assert.Equal(t, "1 2 3 4 [5] 6 7 … 20", pageWindowString(crumb.PageWindow(2)))
+}
+
+func TestPageWindowEnd(t *testing.T) {
+	crumb := &http_util.PageBreadCrumb{
+		URL:         http_util.NewUrl("/list"),
+		PageNoParam: "pn",
+		PageNo:      19,
+		PageCount:   20,
+		End:         true,
+	}
+	assert.Equal(t, "1 … 18 19 [20]", pageWindowString(crumb.PageWindow(2)))
+	crumb.PageNo = 16
+	assert.Equal(t, "1 … 15 16 [17] 18 19 20", pageWindowString(crumb.PageWindow(2)))
+}
+
+func TestPageWindowUnknownCount(t *testing.T) {
+	crumb := &http_util.PageBreadCrumb{
+		URL:         http_util.NewUrl("/list"),
+		PageNoParam: "pn",
+		PageNo:      5,
+	}
+	assert.Equal(t, "1 … 4 5 [6] 7 …", pageWindowString(crumb.PageWindow(2)))
+	crumb.End = true
+	assert.Equal(t, "1 … 4 5 [6]", pageWindowString(crumb.PageWindow(2)))
+	crumb.PageNo = 0
+	assert.Equal(t, "[1]", pageWindowString(crumb.PageWindow(2)))
+}
+
+func pageWindowString(links []http_util.PageLink) string {
+	parts := make([]string, len(links))
+	for i, link := range links {
+		switch {
+		case link.Ellipsis:
+			parts[i] = "…"
+		case link.Current:
+			parts[i] = fmt.Sprintf("[%d]", link.Number)
+		default:
+			parts[i] = strconv.Itoa(link.Number)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestTryParams(t *testing.T) {
+	assert := assert.New(t)
+	u, err := http_util.TryNewUrl("/list", "a", "1", "b", "2")
+	assert.NoError(err)
+	assert.Equal("/list?a=1&b=2", u.String())
+	u, err = http_util.TryWithParams(u, "a", "3")
+	assert.NoError(err)
+	assert.Equal("/list?a=3&b=2", u.String())
+	u, err = http_util.TryAppendParams(u, "a", "4")
+	assert.NoError(err)
+	assert.Equal("/list?a=3&a=4&b=2", u.String())
+}
+
+func TestTryParamsOdd(t *testing.T) {
+	assert := assert.New(t)
+	base := http_util.NewUrl("/list")
+	_, err := http_util.TryNewUrl("/list", "a")
+	assert.Equal(http_util.ErrOddNameValues, err)
+	_, err = http_util.TryWithParams(base, "a", "1", "b")
+	assert.Equal(http_util.ErrOddNameValues, err)
+	_, err = http_util.TryAppendParams(base, "a")
+	assert.Equal(http_util.ErrOddNameValues, err)
+	assert.Panics(func() { http_util.NewUrl("/list", "a") })
+}
+
+func TestValuesGetInt64(t *testing.T) {
+	assert := assert.New(t)
+	values := http_util.Values{url.Values{
+		"id":    {"1234567890123"},
+		"neg":   {"-5"},
+		"bad":   {"12abc"},
+		"empty": {""},
+	}}
+	id, ok := values.GetInt64("id")
+	assert.True(ok)
+	assert.Equal(int64(1234567890123), id)
+	id, ok = values.GetInt64("neg")
+	assert.True(ok)
+	assert.Equal(int64(-5), id)
+	_, ok = values.GetInt64("bad")
+	assert.False(ok)
+	_, ok = values.GetInt64("empty")
+	assert.False(ok)
+	_, ok = values.GetInt64("missing")
+	assert.False(ok)
+	assert.Equal(int64(-5), values.GetInt64Default("neg", 7))
+	assert.Equal(int64(7), values.GetInt64Default("bad", 7))
+	assert.Equal(int64(7), values.GetInt64Default("missing", 7))
+}
+
+func TestWriteTemplateOrError(t *testing.T) {
+	assert := assert.New(t)
+	tmpl := template.Must(template.New("page").Parse(
+		"<p>Hello {{.Name}}</p><p>{{.Fail}}</p>"))
+	w := httptest.NewRecorder()
+	http_util.WriteTemplateOrError(w, tmpl, &templateData{Name: "Bob"})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("<p>Hello Bob</p><p>ok</p>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	http_util.WriteTemplateOrError(
+		w, tmpl, &templateData{Name: "Bob", fail: true})
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.NotContains(w.Body.String(), "Hello")
+	assert.Contains(w.Body.String(), "500 Internal Server Error")
+}
+
+type templateData struct {
+	Name string
+	fail bool
+}
+
+func (d *templateData) Fail() (string, error) {
+	if d.fail {
+		return "", errors.New("http_util_test: template failed")
+	}
+	return "ok", nil
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	assert := assert.New(t)
+	handler := http_util.SecurityHeaders(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal("nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal("DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(
+		"default-src 'self'", w.Header().Get("Content-Security-Policy"))
+	assert.Equal("hello", w.Body.String())
+}
+
+func TestSecurityHeadersHandlerWins(t *testing.T) {
+	assert := assert.New(t)
+	handler := http_util.SecurityHeaders(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		http_util.ContentSecurityPolicy("default-src 'self' cdn.example.com"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.Equal("SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	assert.Equal(
+		"default-src 'self' cdn.example.com",
+		w.Header().Get("Content-Security-Policy"))
+	assert.Equal("nosniff", w.Header().Get("X-Content-Type-Options"))
+}
+
+func TestSecurityHeadersOmitted(t *testing.T) {
+	assert := assert.New(t)
+	handler := http_util.SecurityHeaders(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		http_util.FrameOptions(""),
+		http_util.ContentSecurityPolicy(""))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	_, ok := w.Header()["X-Frame-Options"]
+	assert.False(ok)
+	_, ok = w.Header()["Content-Security-Policy"]
+	assert.False(ok)
+	assert.Equal("nosniff", w.Header().Get("X-Content-Type-Options"))
+}
@@ -1,39 +1,303 @@
 // Package idset handles comma separated list of int64.
+//
+// An IdSet may also contain ranges of ids in "lo-hi" notation such as
+// "1,5-8,10" which contains 1, 5, 6, 7, 8, and 10.
 package idset
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"sort"
 	"strconv"
 	"strings"
 )
 
+// MaxRangeSize is the most ids that a single "lo-hi" range may contain.
+// Validate rejects sets with larger ranges, and Map, Slice, and the
+// methods built on them treat such sets as malformed.
+const MaxRangeSize = 100000
+
 // IdSet is a comma separated set of record Ids.
 type IdSet string
 
-// Contains returns true if this set contains id
+// Contains returns true if this set contains id. Contains checks each
+// range in place rather than expanding it.
 func (s IdSet) Contains(id int64) bool {
-	m, err := s.Map()
+	segs, err := s.segments()
 	if err != nil {
 		return false
 	}
-	return m[id]
+	for _, seg := range segs {
+		if seg.lo <= id && id <= seg.hi {
+			return true
+		}
+	}
+	return false
 }
 
 // Map converts this set to a map.
 func (s IdSet) Map() (map[int64]bool, error) {
+	ids, err := s.parse()
+	if err != nil {
+		return map[int64]bool{}, err
+	}
+	return toMap(ids), nil
+}
+
+// Slice returns the ids in this set sorted in ascending order. If this
+// set is empty, Slice returns an empty, non-nil slice.
+func (s IdSet) Slice() ([]int64, error) {
+	ids, err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(int64Slice(ids))
+	result := ids[:0]
+	for i := range ids {
+		if i == 0 || ids[i] != ids[i-1] {
+			result = append(result, ids[i])
+		}
+	}
+	return result, nil
+}
+
+// Validate returns an error if this set contains an empty id, an id that
+// is not a number, a negative id, a range whose low end exceeds its high
+// end, a range of more than MaxRangeSize ids, or a duplicate id. Contains,
+// Map, and Slice are more lenient in that they accept empty sets and
+// duplicate or negative ids.
+func (s IdSet) Validate() error {
 	if s == "" {
-		return map[int64]bool{}, nil
+		return nil
+	}
+	var segs []segment
+	for i, str := range strings.Split(string(s), ",") {
+		if str == "" {
+			return fmt.Errorf("idset: empty id at position %d in %q", i, s)
+		}
+		lo, hi, err := parseSegment(str)
+		if err != nil {
+			return fmt.Errorf("idset: invalid id %q in %q: %w", str, s, err)
+		}
+		if lo < 0 {
+			return fmt.Errorf("idset: negative id %d in %q", lo, s)
+		}
+		if lo > hi {
+			return fmt.Errorf("idset: empty range %q in %q", str, s)
+		}
+		if tooBig(lo, hi) {
+			return fmt.Errorf(
+				"idset: range %q in %q exceeds %d ids", str, s, MaxRangeSize)
+		}
+		segs = append(segs, segment{lo: lo, hi: hi})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].lo < segs[j].lo })
+	for i := 1; i < len(segs); i++ {
+		if segs[i].lo <= segs[i-1].hi {
+			return fmt.Errorf("idset: duplicate id %d in %q", segs[i].lo, s)
+		}
+	}
+	return nil
+}
+
+// CompactString returns this set in sorted order with each run of three
+// or more consecutive ids collapsed into "lo-hi" notation. For example,
+// the set "5,1,2,3,4,8,9" becomes "1-5,8,9". Like Contains, CompactString
+// treats a malformed set as empty.
+func (s IdSet) CompactString() string {
+	ids, err := s.Slice()
+	if err != nil {
+		return ""
+	}
+	var parts []string
+	for start := 0; start < len(ids); {
+		end := start + 1
+		for end < len(ids) && ids[end] == ids[end-1]+1 {
+			end++
+		}
+		if end-start >= 3 {
+			parts = append(parts, fmt.Sprintf("%d-%d", ids[start], ids[end-1]))
+		} else {
+			for _, id := range ids[start:end] {
+				parts = append(parts, strconv.FormatInt(id, 10))
+			}
+		}
+		start = end
+	}
+	return strings.Join(parts, ",")
+}
+
+// MarshalJSON marshals this set as a JSON array of ids in ascending order
+// such as [2,3,9]. MarshalJSON returns an error if this set is malformed.
+func (s IdSet) MarshalJSON() ([]byte, error) {
+	ids, err := s.Slice()
+	if err != nil {
+		return nil, fmt.Errorf("idset: malformed set %q: %w", string(s), err)
+	}
+	return json.Marshal(ids)
+}
+
+// UnmarshalJSON accepts either a JSON array of ids such as [2,3,9] or,
+// for compatibility, a JSON string such as "2,3,9".
+func (s *IdSet) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("\"")) {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		if _, err := IdSet(str).parse(); err != nil {
+			return fmt.Errorf("idset: malformed set %q: %w", str, err)
+		}
+		*s = IdSet(str)
+		return nil
+	}
+	var ids []int64
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return err
+	}
+	*s = newIdSet(toMap(ids))
+	return nil
+}
+
+func (s IdSet) parse() ([]int64, error) {
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, seg := range segs {
+		if tooBig(seg.lo, seg.hi) {
+			return nil, fmt.Errorf(
+				"idset: range %d-%d exceeds %d ids", seg.lo, seg.hi, MaxRangeSize)
+		}
+		for id := seg.lo; id <= seg.hi; id++ {
+			ids = append(ids, id)
+			if id == seg.hi {
+				break
+			}
+		}
+	}
+	if ids == nil {
+		ids = []int64{}
+	}
+	return ids, nil
+}
+
+// segment is a single id or a range of ids from lo to hi inclusive.
+type segment struct {
+	lo, hi int64
+}
+
+func (s IdSet) segments() ([]segment, error) {
+	if s == "" {
+		return nil, nil
 	}
 	strs := strings.Split(string(s), ",")
-	ids := make([]int64, len(strs))
-	for i := range ids {
-		var err error
-		ids[i], err = strconv.ParseInt(strs[i], 10, 64)
+	segs := make([]segment, 0, len(strs))
+	for _, str := range strs {
+		lo, hi, err := parseSegment(str)
 		if err != nil {
-			return map[int64]bool{}, err
+			return nil, err
 		}
+		segs = append(segs, segment{lo: lo, hi: hi})
 	}
-	return toMap(ids), nil
+	return segs, nil
+}
+
+// tooBig returns true if the range from lo to hi has more than
+// MaxRangeSize ids.
+func tooBig(lo, hi int64) bool {
+	return lo <= hi && uint64(hi)-uint64(lo) >= MaxRangeSize
+}
+
+// parseSegment parses either a single id or a range of ids in "lo-hi"
+// notation. For a single id, lo and hi are equal.
+func parseSegment(str string) (lo, hi int64, err error) {
+	// Start searching after the first character so that a leading minus
+	// sign is not mistaken for a range.
+	dash := -1
+	if len(str) > 0 {
+		if idx := strings.IndexByte(str[1:], '-'); idx != -1 {
+			dash = idx + 1
+		}
+	}
+	if dash == -1 {
+		lo, err = strconv.ParseInt(str, 10, 64)
+		return lo, lo, err
+	}
+	lo, err = strconv.ParseInt(str[:dash], 10, 64)
+	if err != nil {
+		return
+	}
+	hi, err = strconv.ParseInt(str[dash+1:], 10, 64)
+	return
+}
+
+// Add returns a new set containing the ids in this set plus id. Like
+// Contains, Add treats a malformed set as empty.
+func (s IdSet) Add(id int64) IdSet {
+	m := s.lenientMap()
+	m[id] = true
+	return newIdSet(m)
+}
+
+// Remove returns a new set containing the ids in this set except id. Like
+// Contains, Remove treats a malformed set as empty.
+func (s IdSet) Remove(id int64) IdSet {
+	m := s.lenientMap()
+	delete(m, id)
+	return newIdSet(m)
+}
+
+// Union returns the ids in this set or other. Like Contains, Union treats
+// a malformed set as empty.
+func (s IdSet) Union(other IdSet) IdSet {
+	result := s.lenientMap()
+	for id := range other.lenientMap() {
+		result[id] = true
+	}
+	return newIdSet(result)
+}
+
+// Intersect returns the ids in both this set and other. Like Contains,
+// Intersect treats a malformed set as empty.
+func (s IdSet) Intersect(other IdSet) IdSet {
+	otherMap := other.lenientMap()
+	result := make(map[int64]bool)
+	for id := range s.lenientMap() {
+		if otherMap[id] {
+			result[id] = true
+		}
+	}
+	return newIdSet(result)
+}
+
+// Difference returns the ids in this set that are not in other. Like
+// Contains, Difference treats a malformed set as empty.
+func (s IdSet) Difference(other IdSet) IdSet {
+	result := s.lenientMap()
+	for id := range other.lenientMap() {
+		delete(result, id)
+	}
+	return newIdSet(result)
+}
+
+// Range returns the set of ids from lo to hi inclusive. If lo > hi, Range
+// returns the empty set. Like CompactString, Range writes three or more
+// ids in "lo-hi" notation, so Range never expands the ids it contains.
+// Range may return a range of more than MaxRangeSize ids which Contains
+// accepts but Validate rejects.
+func Range(lo, hi int64) IdSet {
+	switch {
+	case lo > hi:
+		return ""
+	case lo == hi:
+		return IdSet(strconv.FormatInt(lo, 10))
+	case lo+1 == hi:
+		return IdSet(fmt.Sprintf("%d,%d", lo, hi))
+	}
+	return IdSet(fmt.Sprintf("%d-%d", lo, hi))
 }
 
 // New creates a new IdSet from given ids.
@@ -56,6 +320,14 @@ func newIdSet(m map[int64]bool) IdSet {
 	return IdSet(strings.Join(strs, ","))
 }
 
+func (s IdSet) lenientMap() map[int64]bool {
+	m, err := s.Map()
+	if err != nil {
+		return map[int64]bool{}
+	}
+	return m
+}
+
 func toMap(ids []int64) map[int64]bool {
 	result := make(map[int64]bool, len(ids))
 	for _, id := range ids {
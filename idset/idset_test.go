@@ -1,7 +1,10 @@
 package idset_test
 
 import (
+	"encoding/json"
 	"github.com/keep94/toolbox/idset"
+	"math"
+	"reflect"
 	"testing"
 )
 
@@ -69,3 +72,253 @@ func TestMap(t *testing.T) {
 		t.Error("Expected map length to be 0")
 	}
 }
+
+func TestUnion(t *testing.T) {
+	assertIdSet(t, "1,2,3,5", idset.IdSet("1,3,5").Union("2,3"))
+	assertIdSet(t, "1,2,3,4", idset.IdSet("1,2").Union("3,4"))
+	assertIdSet(t, "1,2", idset.IdSet("").Union("2,1"))
+	assertIdSet(t, "1,2", idset.IdSet("1,2").Union(""))
+	assertIdSet(t, "1,2", idset.IdSet("1,2").Union("hello"))
+}
+
+func TestIntersect(t *testing.T) {
+	assertIdSet(t, "3", idset.IdSet("1,3,5").Intersect("2,3"))
+	assertIdSet(t, "", idset.IdSet("1,2").Intersect("3,4"))
+	assertIdSet(t, "", idset.IdSet("").Intersect("1,2"))
+	assertIdSet(t, "", idset.IdSet("1,2").Intersect(""))
+	assertIdSet(t, "", idset.IdSet("1,2").Intersect("hello"))
+}
+
+func TestDifference(t *testing.T) {
+	assertIdSet(t, "1,5", idset.IdSet("1,3,5").Difference("2,3"))
+	assertIdSet(t, "1,2", idset.IdSet("1,2").Difference("3,4"))
+	assertIdSet(t, "", idset.IdSet("").Difference("1,2"))
+	assertIdSet(t, "1,2", idset.IdSet("1,2").Difference(""))
+	assertIdSet(t, "", idset.IdSet("hello").Difference("1"))
+}
+
+func TestAdd(t *testing.T) {
+	assertIdSet(t, "5", idset.IdSet("").Add(5))
+	assertIdSet(t, "2,3,9", idset.IdSet("2,9").Add(3))
+	assertIdSet(t, "2,9", idset.IdSet("2,9").Add(9))
+	assertIdSet(t, "1,2,9", idset.IdSet("2,9").Add(1))
+}
+
+func TestRemove(t *testing.T) {
+	assertIdSet(t, "", idset.IdSet("5").Remove(5))
+	assertIdSet(t, "2,9", idset.IdSet("2,3,9").Remove(3))
+	assertIdSet(t, "2,9", idset.IdSet("2,9").Remove(4))
+	assertIdSet(t, "", idset.IdSet("").Remove(4))
+}
+
+func TestSlice(t *testing.T) {
+	ids, err := idset.IdSet("9,2,13,3,2").Slice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{2, 3, 9, 13}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected %v, got %v", expected, ids)
+	}
+
+	ids, err = idset.IdSet("").Slice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids == nil || len(ids) != 0 {
+		t.Errorf("Expected empty non-nil slice, got %#v", ids)
+	}
+
+	_, err = idset.IdSet("2,hello").Slice()
+	if err == nil {
+		t.Error("Expected error to be thrown")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, valid := range []idset.IdSet{"", "0", "2,3,9", "9,2"} {
+		if err := valid.Validate(); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", valid, err)
+		}
+	}
+	invalid := []idset.IdSet{
+		"2,2,3", "2,,3", ",2", "2,", "2,-3", "2,x", " 2",
+	}
+	for _, set := range invalid {
+		if err := set.Validate(); err == nil {
+			t.Errorf("Expected %q to be invalid", set)
+		}
+	}
+
+	// Map stays lenient
+	m, err := idset.IdSet("2,2,-3").Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || !m[2] || !m[-3] {
+		t.Errorf("Unexpected map %v", m)
+	}
+}
+
+func TestRange(t *testing.T) {
+	assertIdSet(t, "1000-1003", idset.Range(1000, 1003))
+	assertIdSet(t, "7,8", idset.Range(7, 8))
+	assertIdSet(t, "7", idset.Range(7, 7))
+	assertIdSet(t, "", idset.Range(8, 7))
+	if !idset.Range(1000, 1100).Contains(1050) {
+		t.Error("Expected range to contain 1050")
+	}
+	ids, err := idset.Range(3, 6).Slice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int64{3, 4, 5, 6}; !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected %v, got %v", expected, ids)
+	}
+}
+
+func TestRangeExtremeBounds(t *testing.T) {
+	all := idset.Range(0, math.MaxInt64)
+	assertIdSet(t, "0-9223372036854775807", all)
+	if !all.Contains(math.MaxInt64) || !all.Contains(0) || all.Contains(-1) {
+		t.Error("Contains wrong for extreme range")
+	}
+	if err := all.Validate(); err == nil {
+		t.Error("Expected extreme range to be invalid")
+	}
+	huge := idset.Range(0, 1<<40)
+	if !huge.Contains(1<<39) || huge.Contains(1<<40+1) {
+		t.Error("Contains wrong for huge range")
+	}
+	widest := idset.Range(math.MinInt64, math.MaxInt64)
+	if !widest.Contains(math.MinInt64) || !widest.Contains(math.MaxInt64) {
+		t.Error("Contains wrong for widest range")
+	}
+	assertIdSet(t, "9223372036854775806,9223372036854775807",
+		idset.Range(math.MaxInt64-1, math.MaxInt64))
+}
+
+func TestCompactString(t *testing.T) {
+	if actual := idset.Range(1000, 1100).CompactString(); actual != "1000-1100" {
+		t.Errorf("Expected 1000-1100, got %s", actual)
+	}
+	mixed := idset.IdSet("9,5,1,2,3,4,11,12,20")
+	if actual := mixed.CompactString(); actual != "1-5,9,11,12,20" {
+		t.Errorf("Expected 1-5,9,11,12,20, got %s", actual)
+	}
+	if actual := idset.IdSet("").CompactString(); actual != "" {
+		t.Errorf("Expected empty string, got %s", actual)
+	}
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	original := idset.IdSet("1,2,3,4,5,9,11,12,20")
+	compact := idset.IdSet(original.CompactString())
+	if err := compact.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := compact.Slice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{1, 2, 3, 4, 5, 9, 11, 12, 20}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected %v, got %v", expected, ids)
+	}
+	assertIdSet(t, original, compact.Union(""))
+	if !compact.Contains(4) || compact.Contains(6) {
+		t.Error("Contains wrong for compact set")
+	}
+	for _, invalid := range []idset.IdSet{"5-3", "1-3,2", "1-", "-2-3"} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestHugeRange(t *testing.T) {
+	huge := idset.IdSet("1-9223372036854775807")
+	if !huge.Contains(5000000000) || huge.Contains(0) {
+		t.Error("Contains wrong for huge range")
+	}
+	if err := huge.Validate(); err == nil {
+		t.Error("Expected huge range to be invalid")
+	}
+	if _, err := huge.Slice(); err == nil {
+		t.Error("Expected error slicing huge range")
+	}
+	if actual := huge.CompactString(); actual != "" {
+		t.Errorf("Expected empty string, got %s", actual)
+	}
+	if err := idset.IdSet("0-99999").Validate(); err != nil {
+		t.Errorf("Expected range of MaxRangeSize ids to be valid, got %v", err)
+	}
+	if err := idset.IdSet("0-100000").Validate(); err == nil {
+		t.Error("Expected range of more than MaxRangeSize ids to be invalid")
+	}
+	if err := idset.IdSet("1-3,10-20,15").Validate(); err == nil {
+		t.Error("Expected overlapping ranges to be invalid")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type response struct {
+		Ids idset.IdSet `json:"ids"`
+	}
+	data, err := json.Marshal(response{Ids: "9,2,3,5-6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"ids":[2,3,5,6,9]}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+	var r response
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	assertIdSet(t, "2,3,5,6,9", r.Ids)
+	if err := json.Unmarshal([]byte(`{"ids":"2,3,9"}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	assertIdSet(t, "2,3,9", r.Ids)
+}
+
+func TestJSONEmpty(t *testing.T) {
+	var empty idset.IdSet
+	data, err := json.Marshal(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" {
+		t.Errorf("Expected [], got %s", data)
+	}
+	result := idset.IdSet("1")
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	assertIdSet(t, "", result)
+	result = "1"
+	if err := json.Unmarshal([]byte(`""`), &result); err != nil {
+		t.Fatal(err)
+	}
+	assertIdSet(t, "", result)
+}
+
+func TestJSONMalformed(t *testing.T) {
+	var result idset.IdSet
+	for _, data := range []string{`"2,x"`, `[2,"x"]`, `{}`, `[1.5]`} {
+		if err := json.Unmarshal([]byte(data), &result); err == nil {
+			t.Errorf("Expected error unmarshaling %s", data)
+		}
+	}
+	if _, err := json.Marshal(idset.IdSet("2,x")); err == nil {
+		t.Error("Expected error marshaling malformed set")
+	}
+}
+
+func assertIdSet(t *testing.T, expected, actual idset.IdSet) {
+	t.Helper()
+	if expected != actual {
+		t.Errorf("Expected %s, got %s", expected, actual)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 	"io"
 )
@@ -14,6 +17,28 @@ const (
 	DefaultReps = 1972
 )
 
+const (
+	kSaltLen = 8
+	kKeyLen  = 32
+
+	// salt + key as produced by NewHMAC
+	kLegacyLen = kSaltLen + kKeyLen
+
+	// Version byte of the self describing pbkdf2 format
+	kPBKDF2Version = 1
+
+	// version byte + 4 byte reps + salt + key
+	kPBKDF2Len = 1 + 4 + kSaltLen + kKeyLen
+
+	kArgon2SaltLen = 16
+
+	// Version byte of the self describing argon2id format
+	kArgon2Version = 2
+
+	// version byte + 4 byte time + 4 byte memory + 1 byte threads + salt + key
+	kArgon2Len = 1 + 4 + 4 + 1 + kArgon2SaltLen + kKeyLen
+)
+
 var (
 	// Default salt
 	DefaultSalt = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xAB, 0x24}
@@ -23,7 +48,7 @@ var (
 // Resulting hash is 40 bytes and contains 8 bytes of random salt. The larger
 // reps is the longer it takes to build the hash.
 func NewHMAC(plain []byte, reps int) []byte {
-	salt := Random(8)
+	salt := Random(kSaltLen)
 	kdf := KDF(plain, salt, reps)
 	result := make([]byte, len(salt)+len(kdf))
 	idx := copy(result, salt)
@@ -34,7 +59,115 @@ func NewHMAC(plain []byte, reps int) []byte {
 // VerifyHMAC returns true if mac is a valid one way hash of plain. reps
 // must be the same as what was passed to NewHMAC to create the one way hash.
 func VerifyHMAC(plain []byte, mac []byte, reps int) bool {
-	return hmac.Equal(mac[8:], KDF(plain, mac[:8], reps))
+	if len(mac) < kSaltLen {
+		return false
+	}
+	return hmac.Equal(mac[kSaltLen:], KDF(plain, mac[:kSaltLen], reps))
+}
+
+// NewHMACV2 works like NewHMAC except that the resulting hash is self
+// describing. It includes a version byte and reps so that VerifyHMACV2
+// does not need the caller to supply reps. Resulting hash is 45 bytes.
+// NewHMACV2 panics if reps is not positive.
+func NewHMACV2(plain []byte, reps int) []byte {
+	if reps <= 0 || int64(reps) > int64(^uint32(0)) {
+		panic("reps out of range")
+	}
+	salt := Random(kSaltLen)
+	result := make([]byte, 5, kPBKDF2Len)
+	result[0] = kPBKDF2Version
+	binary.BigEndian.PutUint32(result[1:5], uint32(reps))
+	result = append(result, salt...)
+	return append(result, KDF(plain, salt, reps)...)
+}
+
+// NewArgon2HMAC works like NewHMACV2 except that it uses argon2id
+// instead of pbkdf2. time, memory, and threads are the argon2id
+// parameters; memory is in KiB. The resulting hash includes these
+// parameters so that VerifyHMACV2 can verify it. Resulting hash is
+// 58 bytes. NewArgon2HMAC panics if time or threads is 0.
+func NewArgon2HMAC(
+	plain []byte, time, memory uint32, threads uint8) []byte {
+	if time == 0 || threads == 0 {
+		panic("time and threads must be positive")
+	}
+	salt := Random(kArgon2SaltLen)
+	result := make([]byte, 10, kArgon2Len)
+	result[0] = kArgon2Version
+	binary.BigEndian.PutUint32(result[1:5], time)
+	binary.BigEndian.PutUint32(result[5:9], memory)
+	result[9] = threads
+	result = append(result, salt...)
+	return append(result, Argon2Key(plain, salt, time, memory, threads)...)
+}
+
+// VerifyHMACV2 returns true if mac is a valid one way hash of plain.
+// mac must come from NewHMACV2 or NewArgon2HMAC. VerifyHMACV2 returns
+// false if mac is malformed.
+func VerifyHMACV2(plain []byte, mac []byte) bool {
+	if len(mac) == 0 {
+		return false
+	}
+	switch mac[0] {
+	case kPBKDF2Version:
+		return verifyPBKDF2(plain, mac)
+	case kArgon2Version:
+		return verifyArgon2(plain, mac)
+	default:
+		return false
+	}
+}
+
+// VerifyAndCheckRehash returns true for ok if mac is a valid one way hash
+// of plain. mac may come from NewHMAC, NewHMACV2, or NewArgon2HMAC.
+// reps is the current policy for the number of repititions. When ok is
+// true, VerifyAndCheckRehash also returns true for needsRehash if mac
+// is weaker than the current policy so that the caller can replace mac
+// with NewHMACV2(plain, reps). A mac from NewHMAC needs rehash because it
+// does not record its reps; VerifyAndCheckRehash verifies it with reps.
+// A mac from NewHMACV2 needs rehash if it was created with fewer than reps
+// repititions. A mac from NewArgon2HMAC never needs rehash.
+func VerifyAndCheckRehash(
+	plain []byte, mac []byte, reps int) (ok, needsRehash bool) {
+	switch {
+	case len(mac) == kLegacyLen:
+		ok = VerifyHMAC(plain, mac, reps)
+		return ok, ok
+	case len(mac) == kPBKDF2Len && mac[0] == kPBKDF2Version:
+		ok = verifyPBKDF2(plain, mac)
+		storedReps := int64(binary.BigEndian.Uint32(mac[1:5]))
+		return ok, ok && storedReps < int64(reps)
+	default:
+		return VerifyHMACV2(plain, mac), false
+	}
+}
+
+func verifyArgon2(plain []byte, mac []byte) bool {
+	if len(mac) != kArgon2Len {
+		return false
+	}
+	time := binary.BigEndian.Uint32(mac[1:5])
+	memory := binary.BigEndian.Uint32(mac[5:9])
+	threads := mac[9]
+	if time == 0 || threads == 0 {
+		return false
+	}
+	salt := mac[10 : 10+kArgon2SaltLen]
+	return hmac.Equal(
+		mac[10+kArgon2SaltLen:],
+		Argon2Key(plain, salt, time, memory, threads))
+}
+
+func verifyPBKDF2(plain []byte, mac []byte) bool {
+	if len(mac) != kPBKDF2Len {
+		return false
+	}
+	reps := int(binary.BigEndian.Uint32(mac[1:5]))
+	if reps <= 0 {
+		return false
+	}
+	salt := mac[5 : 5+kSaltLen]
+	return hmac.Equal(mac[5+kSaltLen:], KDF(plain, salt, reps))
 }
 
 // KDF derives a 32 byte encryption key from plain by using salt and reps
@@ -42,14 +175,51 @@ func VerifyHMAC(plain []byte, mac []byte, reps int) bool {
 // For a given plain text, salt, and reps, KDF will consistently produce
 // the same encryption key.
 func KDF(plain []byte, salt []byte, reps int) []byte {
-	return pbkdf2.Key(plain, salt, reps, 32, sha256.New)
+	return pbkdf2.Key(plain, salt, reps, kKeyLen, sha256.New)
+}
+
+// Argon2Key derives a 32 byte encryption key from plain using argon2id.
+// time is the number of passes; memory is the memory to use in KiB;
+// threads is the degree of parallelism. For a given plain text, salt,
+// and parameters, Argon2Key will consistently produce the same key.
+func Argon2Key(
+	plain []byte, salt []byte, time, memory uint32, threads uint8) []byte {
+	return argon2.IDKey(plain, salt, time, memory, threads, kKeyLen)
 }
 
 // Random produces a random sequence of count bytes
 func Random(count int) []byte {
-	result := make([]byte, count)
-	if _, err := io.ReadFull(rand.Reader, result); err != nil {
+	result, err := RandomFrom(rand.Reader, count)
+	if err != nil {
 		panic(err)
 	}
 	return result
 }
+
+// RandomFrom reads count bytes from r. Unlike Random, RandomFrom returns
+// an error instead of panicking if r can't supply count bytes. Passing a
+// fixed reader to RandomFrom gives deterministic results for testing.
+func RandomFrom(r io.Reader, count int) ([]byte, error) {
+	result := make([]byte, count)
+	if _, err := io.ReadFull(r, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SecureCompare returns true if a and b are equal. SecureCompare takes
+// the same time for all inputs of a given length so that comparing a
+// secret to an attempt does not reveal how much of the attempt is
+// correct. Because SecureCompare compares SHA-256 digests of a and b,
+// inputs of different lengths don't return early either.
+func SecureCompare(a, b []byte) bool {
+	aDigest := sha256.Sum256(a)
+	bDigest := sha256.Sum256(b)
+	return subtle.ConstantTimeCompare(aDigest[:], bDigest[:]) == 1
+}
+
+// SecureCompareString works like SecureCompare except that it compares
+// strings.
+func SecureCompareString(a, b string) bool {
+	return SecureCompare([]byte(a), []byte(b))
+}
@@ -1,8 +1,10 @@
 package kdf_test
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"github.com/keep94/toolbox/kdf"
+	"io"
 	"testing"
 )
 
@@ -26,6 +28,128 @@ func TestHMAC(t *testing.T) {
 	}
 }
 
+func TestVerifyHMACShortMac(t *testing.T) {
+	if kdf.VerifyHMAC([]byte("aardvark"), []byte{1, 2, 3}, kdf.DefaultReps) {
+		t.Error("Short mac should not verify")
+	}
+}
+
+func TestHMACV2(t *testing.T) {
+	mac := kdf.NewHMACV2([]byte("aardvark"), 100)
+	if len(mac) != 45 {
+		t.Errorf("Expected 45 byte mac, got %d", len(mac))
+	}
+	if !kdf.VerifyHMACV2([]byte("aardvark"), mac) {
+		t.Error("Mac should have verified")
+	}
+	if kdf.VerifyHMACV2([]byte("be"), mac) {
+		t.Error("Mac should not have verified")
+	}
+	if hmac.Equal(mac, kdf.NewHMACV2([]byte("aardvark"), 100)) {
+		t.Error("Macs should not be equal")
+	}
+	// Reps are stored in the mac
+	if !kdf.VerifyHMACV2([]byte("sailboat"), kdf.NewHMACV2([]byte("sailboat"), 7)) {
+		t.Error("Mac with different reps should have verified")
+	}
+}
+
+func TestHMACV2Tampered(t *testing.T) {
+	mac := kdf.NewHMACV2([]byte("aardvark"), 100)
+	for _, idx := range []int{0, 4, 5, 44} {
+		tampered := make([]byte, len(mac))
+		copy(tampered, mac)
+		tampered[idx] ^= 1
+		if kdf.VerifyHMACV2([]byte("aardvark"), tampered) {
+			t.Errorf("Mac tampered at %d should not have verified", idx)
+		}
+	}
+}
+
+func TestHMACV2Truncated(t *testing.T) {
+	mac := kdf.NewHMACV2([]byte("aardvark"), 100)
+	for _, length := range []int{0, 1, 5, 13, 44} {
+		if kdf.VerifyHMACV2([]byte("aardvark"), mac[:length]) {
+			t.Errorf("Mac truncated to %d should not have verified", length)
+		}
+	}
+}
+
+func TestArgon2Key(t *testing.T) {
+	key1 := kdf.Argon2Key([]byte("aardvark"), kdf.DefaultSalt, 1, 1024, 1)
+	key2 := kdf.Argon2Key([]byte("aardvark"), kdf.DefaultSalt, 1, 1024, 1)
+	if !hmac.Equal(key1, key2) {
+		t.Error("Expected keys to be equal")
+	}
+	if len(key1) != 32 {
+		t.Error("Expected key to be 32 bytes")
+	}
+	if hmac.Equal(key1, kdf.Argon2Key([]byte("aardvark"), kdf.DefaultSalt, 2, 1024, 1)) {
+		t.Error("Expected keys with different time not to be equal")
+	}
+	if hmac.Equal(key1, kdf.Argon2Key([]byte("aardvark"), kdf.DefaultSalt, 1, 2048, 1)) {
+		t.Error("Expected keys with different memory not to be equal")
+	}
+	if hmac.Equal(key1, kdf.Argon2Key([]byte("sailboat"), kdf.DefaultSalt, 1, 1024, 1)) {
+		t.Error("Expected keys with different plain text not to be equal")
+	}
+}
+
+func TestArgon2HMAC(t *testing.T) {
+	mac := kdf.NewArgon2HMAC([]byte("aardvark"), 1, 1024, 1)
+	if !kdf.VerifyHMACV2([]byte("aardvark"), mac) {
+		t.Error("Mac should have verified")
+	}
+	if kdf.VerifyHMACV2([]byte("be"), mac) {
+		t.Error("Mac should not have verified")
+	}
+	if kdf.VerifyHMACV2([]byte("aardvark"), mac[:len(mac)-1]) {
+		t.Error("Truncated mac should not have verified")
+	}
+	tampered := make([]byte, len(mac))
+	copy(tampered, mac)
+	tampered[8] ^= 1
+	if kdf.VerifyHMACV2([]byte("aardvark"), tampered) {
+		t.Error("Mac with tampered memory should not have verified")
+	}
+}
+
+func TestVerifyAndCheckRehash(t *testing.T) {
+	plain := []byte("aardvark")
+	assertRehash(t, true, false, plain, kdf.NewHMACV2(plain, 200), 200)
+
+	// Stronger than policy
+	assertRehash(t, true, false, plain, kdf.NewHMACV2(plain, 300), 200)
+
+	// Weaker than policy
+	assertRehash(t, true, true, plain, kdf.NewHMACV2(plain, 100), 200)
+
+	// Original format doesn't record reps
+	assertRehash(t, true, true, plain, kdf.NewHMAC(plain, 200), 200)
+
+	assertRehash(
+		t, true, false, plain, kdf.NewArgon2HMAC(plain, 1, 1024, 1), 200)
+
+	// Invalid
+	assertRehash(t, false, false, []byte("be"), kdf.NewHMACV2(plain, 100), 200)
+	assertRehash(t, false, false, []byte("be"), kdf.NewHMAC(plain, 200), 200)
+	assertRehash(t, false, false, plain, []byte{1, 2}, 200)
+}
+
+func assertRehash(
+	t *testing.T,
+	expectedOk, expectedNeedsRehash bool,
+	plain, mac []byte,
+	reps int) {
+	t.Helper()
+	ok, needsRehash := kdf.VerifyAndCheckRehash(plain, mac, reps)
+	if ok != expectedOk || needsRehash != expectedNeedsRehash {
+		t.Errorf(
+			"Expected (%v, %v), got (%v, %v)",
+			expectedOk, expectedNeedsRehash, ok, needsRehash)
+	}
+}
+
 func TestKDF(t *testing.T) {
 	kdf1 := kdf.KDF([]byte("aardvark"), kdf.DefaultSalt, kdf.DefaultReps)
 	kdf2 := kdf.KDF([]byte("aardvark"), kdf.DefaultSalt, kdf.DefaultReps)
@@ -39,3 +163,48 @@ func TestKDF(t *testing.T) {
 		t.Error("Expected key to be 32 bytes")
 	}
 }
+
+func TestSecureCompare(t *testing.T) {
+	if !kdf.SecureCompare([]byte("secret"), []byte("secret")) {
+		t.Error("Expected equal inputs to compare equal")
+	}
+	if !kdf.SecureCompare(nil, []byte{}) {
+		t.Error("Expected empty inputs to compare equal")
+	}
+	if kdf.SecureCompare([]byte("secret"), []byte("secreT")) {
+		t.Error("Expected same length inputs to differ")
+	}
+	if kdf.SecureCompare([]byte("secret"), []byte("secret2")) {
+		t.Error("Expected different length inputs to differ")
+	}
+	if kdf.SecureCompare([]byte("secret"), nil) {
+		t.Error("Expected non empty and empty inputs to differ")
+	}
+	if !kdf.SecureCompareString("token", "token") {
+		t.Error("Expected equal strings to compare equal")
+	}
+	if kdf.SecureCompareString("token", "toke") {
+		t.Error("Expected different strings to differ")
+	}
+}
+
+func TestRandomFrom(t *testing.T) {
+	source := bytes.NewReader([]byte{1, 2, 3, 4, 5, 6})
+	result, err := kdf.RandomFrom(source, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{1, 2, 3, 4}, result) {
+		t.Errorf("Expected [1 2 3 4], got %v", result)
+	}
+	_, err = kdf.RandomFrom(source, 4)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	if len(kdf.Random(64)) != 64 {
+		t.Error("Expected 64 random bytes")
+	}
+}
@@ -3,26 +3,68 @@ package lockout
 
 import (
 	"sync"
+	"time"
+
+	"github.com/keep94/toolbox/date_util"
 )
 
 // Lockout locks out accounts after consecutive login failures.
 // A nil Lockout pointer means no account lock out.
 type Lockout struct {
-	failures int
-	lock     sync.Mutex
-	counts   map[string]int
+	failures  int
+	threshold func(userName string) int
+	cooldown  time.Duration
+	clock     date_util.Clock
+	lock      sync.Mutex
+	entries   map[string]entry
 }
 
 // New creates a New lockout instance. failures is the number of consecutive
 // failures causing lockout. New panics if failures is less than 1.
 // To disable lockout, use a nil pointer instead of calling New.
-func New(failures int) *Lockout {
+// Accounts locked by the returned instance stay locked.
+func New(failures int, options ...Option) *Lockout {
+	return NewWithCooldown(failures, 0, nil, options...)
+}
+
+// NewWithCooldown works like New except that accounts automatically unlock
+// once cooldown has elapsed since their last failure. When an account
+// unlocks, its consecutive failures reset to zero. A cooldown of 0 means
+// accounts stay locked. clock supplies the current time; nil means
+// use the system clock.
+func NewWithCooldown(
+	failures int,
+	cooldown time.Duration,
+	clock date_util.Clock,
+	options ...Option) *Lockout {
 	if failures < 1 {
 		panic("Failures must be at least 1")
 	}
-	return &Lockout{
+	if clock == nil {
+		clock = date_util.SystemClock{}
+	}
+	result := &Lockout{
 		failures: failures,
-		counts:   make(map[string]int),
+		cooldown: cooldown,
+		clock:    clock,
+		entries:  make(map[string]entry),
+	}
+	for _, option := range options {
+		option(result)
+	}
+	return result
+}
+
+// Option represents an optional setting for New and NewWithCooldown.
+type Option func(l *Lockout)
+
+// ThresholdFunc lets each account have its own number of consecutive
+// failures causing lockout. f returns that number for a given account.
+// If f returns 0, the account never locks; if f returns a negative
+// number, the account uses the failures passed to New or NewWithCooldown.
+func ThresholdFunc(f func(userName string) int) Option {
+	return func(l *Lockout) {
+		l.threshold = f
 	}
 }
 
@@ -35,10 +77,10 @@ func (l *Lockout) Success(userName string) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	// once locked, it stays locked
-	if l.counts[userName] >= l.failures {
+	if l.isLocked(userName, l.current(userName).count) {
 		return
 	}
-	delete(l.counts, userName)
+	delete(l.entries, userName)
 }
 
 // Failure indicates a login failure for given account. Failure returns true
@@ -50,8 +92,14 @@ func (l *Lockout) Failure(userName string) bool {
 	}
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	l.counts[userName]++
-	return l.counts[userName] == l.failures
+	e := l.current(userName)
+	now := l.clock.Now()
+	e.count++
+	e.lastFailure = now
+	e.lastTouched = now
+	l.entries[userName] = e
+	failures := l.failuresFor(userName)
+	return failures > 0 && e.count == failures
 }
 
 // Locked returns true if given account is locked.
@@ -61,5 +109,110 @@ func (l *Lockout) Locked(userName string) bool {
 	}
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	return l.counts[userName] >= l.failures
+	e := l.current(userName)
+	if e.count > 0 {
+		e.lastTouched = l.clock.Now()
+		l.entries[userName] = e
+	}
+	return l.isLocked(userName, e.count)
+}
+
+// Cleanup bounds memory by discarding the consecutive failures of each
+// account not touched by Failure or Locked within olderThan. Cleanup never
+// discards locked accounts so they stay locked. For instances with a
+// cooldown, Cleanup discards locked accounts whose cooldown has elapsed.
+func (l *Lockout) Cleanup(olderThan time.Duration) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.clock.Now()
+	for userName := range l.entries {
+		e := l.current(userName)
+		if l.isLocked(userName, e.count) {
+			continue
+		}
+		if now.Sub(e.lastTouched) >= olderThan {
+			delete(l.entries, userName)
+		}
+	}
+}
+
+// Snapshot returns the number of consecutive failures of each account
+// with failures. Callers can persist the returned map and pass it to
+// Restore later.
+func (l *Lockout) Snapshot() map[string]int {
+	if l == nil {
+		return map[string]int{}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	result := make(map[string]int, len(l.entries))
+	for userName := range l.entries {
+		if e := l.current(userName); e.count > 0 {
+			result[userName] = e.count
+		}
+	}
+	return result
+}
+
+// Restore replaces the state of this instance with counts which maps each
+// account to its consecutive failures. counts usually comes from Snapshot.
+// For instances with a cooldown, the cooldown of each restored account
+// starts over.
+func (l *Lockout) Restore(counts map[string]int) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.clock.Now()
+	l.entries = make(map[string]entry, len(counts))
+	for userName, count := range counts {
+		if count > 0 {
+			l.entries[userName] = entry{
+				count:       count,
+				lastFailure: now,
+				lastTouched: now,
+			}
+		}
+	}
+}
+
+// failuresFor returns the number of consecutive failures that lock
+// userName. 0 means userName never locks.
+func (l *Lockout) failuresFor(userName string) int {
+	if l.threshold == nil {
+		return l.failures
+	}
+	if result := l.threshold(userName); result >= 0 {
+		return result
+	}
+	return l.failures
+}
+
+func (l *Lockout) isLocked(userName string, count int) bool {
+	failures := l.failuresFor(userName)
+	return failures > 0 && count >= failures
+}
+
+// current returns the entry for userName clearing it first if the
+// cooldown has elapsed since its last failure.
+func (l *Lockout) current(userName string) entry {
+	e, ok := l.entries[userName]
+	if !ok {
+		return entry{}
+	}
+	if l.cooldown > 0 && l.clock.Now().Sub(e.lastFailure) >= l.cooldown {
+		delete(l.entries, userName)
+		return entry{}
+	}
+	return e
+}
+
+type entry struct {
+	count       int
+	lastFailure time.Time
+	lastTouched time.Time
 }
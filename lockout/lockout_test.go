@@ -2,7 +2,9 @@ package lockout_test
 
 import (
 	"github.com/keep94/toolbox/lockout"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNil(t *testing.T) {
@@ -51,8 +53,160 @@ func TestAPI(t *testing.T) {
 	assertEquals(t, true, l.Locked("charlie"))
 }
 
+func TestThresholdFunc(t *testing.T) {
+	thresholds := map[string]int{"admin": 1, "service": 0}
+	l := lockout.New(
+		3,
+		lockout.ThresholdFunc(func(userName string) int {
+			if threshold, ok := thresholds[userName]; ok {
+				return threshold
+			}
+			return -1
+		}))
+
+	// admin locks after a single failure
+	assertEquals(t, true, l.Failure("admin"))
+	assertEquals(t, true, l.Locked("admin"))
+
+	// alice falls back to the global threshold
+	assertEquals(t, false, l.Failure("alice"))
+	assertEquals(t, false, l.Failure("alice"))
+	assertEquals(t, false, l.Locked("alice"))
+	assertEquals(t, true, l.Failure("alice"))
+	assertEquals(t, true, l.Locked("alice"))
+
+	// service never locks
+	for i := 0; i < 10; i++ {
+		assertEquals(t, false, l.Failure("service"))
+	}
+	assertEquals(t, false, l.Locked("service"))
+}
+
+func TestCooldown(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l := lockout.NewWithCooldown(2, 10*time.Minute, clock)
+	assertEquals(t, false, l.Failure("alice"))
+	clock.advance(time.Minute)
+	assertEquals(t, true, l.Failure("alice"))
+	assertEquals(t, true, l.Locked("alice"))
+
+	// Still locked just before cooldown elapses since last failure
+	clock.advance(9*time.Minute + 59*time.Second)
+	assertEquals(t, true, l.Locked("alice"))
+	l.Success("alice")
+	assertEquals(t, true, l.Locked("alice"))
+
+	// Unlocked once cooldown elapses and counter resets
+	clock.advance(time.Second)
+	assertEquals(t, false, l.Locked("alice"))
+	assertEquals(t, false, l.Failure("alice"))
+	assertEquals(t, false, l.Locked("alice"))
+	assertEquals(t, true, l.Failure("alice"))
+	assertEquals(t, true, l.Locked("alice"))
+}
+
+func TestCooldownResetsStaleFailures(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l := lockout.NewWithCooldown(2, 10*time.Minute, clock)
+	assertEquals(t, false, l.Failure("bob"))
+	clock.advance(10 * time.Minute)
+	// First failure has expired so this is the first consecutive failure
+	assertEquals(t, false, l.Failure("bob"))
+	assertEquals(t, false, l.Locked("bob"))
+}
+
+func TestCleanup(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l := lockout.NewWithCooldown(2, 0, clock)
+	assertEquals(t, false, l.Failure("alice"))
+	assertEquals(t, false, l.Failure("bob"))
+	assertEquals(t, true, l.Failure("bob"))
+	clock.advance(time.Hour)
+
+	// Touching bob keeps bob around
+	assertEquals(t, true, l.Locked("bob"))
+	clock.advance(time.Minute)
+	l.Cleanup(time.Hour)
+
+	// bob survives cleanup and stays locked
+	assertEquals(t, true, l.Locked("bob"))
+
+	// alice's stale failure was evicted so alice needs two more failures
+	assertEquals(t, false, l.Failure("alice"))
+	assertEquals(t, false, l.Locked("alice"))
+	assertEquals(t, true, l.Failure("alice"))
+
+	// Cleanup keeps locked accounts even when they are stale
+	clock.advance(2 * time.Hour)
+	l.Cleanup(time.Hour)
+	assertEquals(t, true, l.Locked("bob"))
+
+	// Cleanup on a nil Lockout is a no-op
+	var nilLockout *lockout.Lockout
+	nilLockout.Cleanup(time.Hour)
+}
+
+func TestCleanupCooldown(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l := lockout.NewWithCooldown(2, 3*time.Hour, clock)
+	l.Failure("bob")
+	l.Failure("bob")
+	clock.advance(2 * time.Hour)
+
+	// bob is stale but still within cooldown so bob stays locked
+	l.Cleanup(time.Hour)
+	if !reflect.DeepEqual(map[string]int{"bob": 2}, l.Snapshot()) {
+		t.Error("Expected Cleanup to keep bob")
+	}
+	clock.advance(time.Hour)
+	l.Cleanup(time.Hour)
+	assertEquals(t, false, l.Locked("bob"))
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	l := lockout.New(3)
+	l.Failure("alice")
+	l.Failure("bob")
+	l.Failure("bob")
+	l.Failure("bob")
+	snapshot := l.Snapshot()
+	expected := map[string]int{"alice": 1, "bob": 3}
+	if !reflect.DeepEqual(expected, snapshot) {
+		t.Errorf("Expected %v, got %v", expected, snapshot)
+	}
+
+	// Snapshot is a copy
+	snapshot["alice"] = 2
+	assertEquals(t, false, l.Failure("alice"))
+
+	restored := lockout.New(3)
+	restored.Restore(map[string]int{"alice": 1, "bob": 3})
+	assertEquals(t, true, restored.Locked("bob"))
+	restored.Success("bob")
+	assertEquals(t, true, restored.Locked("bob"))
+	assertEquals(t, false, restored.Locked("alice"))
+	assertEquals(t, false, restored.Failure("alice"))
+	assertEquals(t, true, restored.Failure("alice"))
+	if !reflect.DeepEqual(
+		map[string]int{"alice": 3, "bob": 3}, restored.Snapshot()) {
+		t.Error("Snapshot did not match restored state")
+	}
+}
+
 func assertEquals(t *testing.T, expected, actual bool) {
 	if expected != actual {
 		t.Errorf("Expected %v, got %v", expected, actual)
 	}
 }
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
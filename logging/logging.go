@@ -2,6 +2,8 @@
 package logging
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/keep94/weblogs"
 	"github.com/keep94/weblogs/loggers"
@@ -14,22 +16,96 @@ type key int
 
 const (
 	kUserName key = iota
+	kRequestId
+	kValues
 )
 
+// WithValues returns a shallow copy of r that can store logging values
+// such as the user name outside of the weblogs handler. WithValues is
+// useful for testing handlers that call SetUserName or SetRequestId.
+func WithValues(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(
+		r.Context(), kValues, make(map[interface{}]interface{})))
+}
+
 // SetUserName sets the current user name for logging.
 func SetUserName(r *http.Request, name string) {
-	values := weblogs.Values(r)
+	values := valuesFor(r)
 	if values != nil {
 		values[kUserName] = name
 	}
 }
 
+// GetUserName returns the user name set with SetUserName or the empty
+// string if none has been set.
+func GetUserName(r *http.Request) string {
+	name, _ := valuesFor(r)[kUserName].(string)
+	return name
+}
+
+// SetRequestId sets the correlation id of the current request for logging.
+func SetRequestId(r *http.Request, id string) {
+	values := valuesFor(r)
+	if values != nil {
+		values[kRequestId] = id
+	}
+}
+
+// GetRequestId returns the correlation id of the current request or the
+// empty string if none has been set.
+func GetRequestId(r *http.Request) string {
+	id, _ := valuesFor(r)[kRequestId].(string)
+	return id
+}
+
+// valuesFor returns the weblogs values for r or the values that
+// WithValues added to r. valuesFor returns nil if r has neither.
+func valuesFor(r *http.Request) map[interface{}]interface{} {
+	if values := weblogs.Values(r); values != nil {
+		return values
+	}
+	values, _ := r.Context().Value(kValues).(map[interface{}]interface{})
+	return values
+}
+
 // ApacheCommonLoggerWithLatency provides apache common logs with latency
-// in milliseconds following content size.
+// in milliseconds following content size and the request id set with
+// SetRequestId following latency. A missing request id shows as "-".
 func ApacheCommonLoggerWithLatency() weblogs.Logger {
 	return commonLogger{}
 }
 
+// JSONLogger provides access logs as JSON objects, one per line. Each
+// object has the fields time, remote_addr, user, method, path, status,
+// size, latency_ms, and request_id. A missing user or request id shows
+// as "-".
+func JSONLogger() weblogs.Logger {
+	return jsonLogger{}
+}
+
+// Sampled returns a logger that logs only the records of logger for which
+// shouldLog returns true. The returned logger uses logger to take snapshots
+// of requests and to capture responses, so shouldLog can type assert the
+// R and W fields of the records it receives to whatever logger uses.
+// Sampled is useful for dropping successful requests to health check
+// endpoints while still logging their errors.
+func Sampled(
+	logger weblogs.Logger,
+	shouldLog func(log *weblogs.LogRecord) bool) weblogs.Logger {
+	return sampledLogger{Logger: logger, shouldLog: shouldLog}
+}
+
+type sampledLogger struct {
+	weblogs.Logger
+	shouldLog func(log *weblogs.LogRecord) bool
+}
+
+func (l sampledLogger) Log(w io.Writer, log *weblogs.LogRecord) {
+	if l.shouldLog(log) {
+		l.Logger.Log(w, log)
+	}
+}
+
 type loggerBase struct {
 }
 
@@ -48,7 +124,7 @@ type commonLogger struct {
 func (l commonLogger) Log(w io.Writer, log *weblogs.LogRecord) {
 	s := log.R.(*loggers.Snapshot)
 	c := log.W.(*loggers.Capture)
-	fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %d %d\n",
+	fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %d %d %s\n",
 		loggers.StripPort(s.RemoteAddr),
 		userName(log),
 		log.T.Format("02/Jan/2006:15:04:05 -0700"),
@@ -57,11 +133,54 @@ func (l commonLogger) Log(w io.Writer, log *weblogs.LogRecord) {
 		s.Proto,
 		c.Status(),
 		c.Size(),
-		log.Duration/time.Millisecond)
+		log.Duration/time.Millisecond,
+		requestId(log))
+}
+
+type jsonLogger struct {
+	loggerBase
+}
+
+type jsonRecord struct {
+	Time       string `json:"time"`
+	RemoteAddr string `json:"remote_addr"`
+	User       string `json:"user"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Size       int    `json:"size"`
+	LatencyMs  int64  `json:"latency_ms"`
+	RequestId  string `json:"request_id"`
+}
+
+func (l jsonLogger) Log(w io.Writer, log *weblogs.LogRecord) {
+	s := log.R.(*loggers.Snapshot)
+	c := log.W.(*loggers.Capture)
+	record := &jsonRecord{
+		Time:       log.T.Format(time.RFC3339),
+		RemoteAddr: loggers.StripPort(s.RemoteAddr),
+		User:       userName(log),
+		Method:     s.Method,
+		Path:       s.URL.RequestURI(),
+		Status:     c.Status(),
+		Size:       c.Size(),
+		LatencyMs:  int64(log.Duration / time.Millisecond),
+		RequestId:  requestId(log),
+	}
+	// Encode writes the trailing newline
+	json.NewEncoder(w).Encode(record)
 }
 
 func userName(log *weblogs.LogRecord) string {
-	value, ok := log.Values[kUserName]
+	return stringValue(log, kUserName)
+}
+
+func requestId(log *weblogs.LogRecord) string {
+	return stringValue(log, kRequestId)
+}
+
+func stringValue(log *weblogs.LogRecord, k key) string {
+	value, ok := log.Values[k]
 	if ok {
 		return value.(string)
 	}
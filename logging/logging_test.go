@@ -0,0 +1,172 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keep94/toolbox/logging"
+	"github.com/keep94/weblogs"
+	"github.com/keep94/weblogs/loggers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLogger(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.SetUserName(r, "bob")
+		logging.SetRequestId(r, "req-42")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	logged := weblogs.HandlerWithOptions(handler, &weblogs.Options{
+		Writer: &buf,
+		Logger: logging.JSONLogger(),
+		Now:    fakeNow(),
+	})
+	r := httptest.NewRequest("POST", "/items?id=3", nil)
+	r.RemoteAddr = "192.168.1.5:4321"
+	logged.ServeHTTP(httptest.NewRecorder(), r)
+
+	var record map[string]interface{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(map[string]interface{}{
+		"time":        "2013-11-14T10:30:00Z",
+		"remote_addr": "192.168.1.5",
+		"user":        "bob",
+		"method":      "POST",
+		"path":        "/items?id=3",
+		"status":      201.0,
+		"size":        5.0,
+		"latency_ms":  250.0,
+		"request_id":  "req-42",
+	}, record)
+}
+
+func TestJSONLoggerNoUser(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	logged := weblogs.HandlerWithOptions(handler, &weblogs.Options{
+		Writer: &buf,
+		Logger: logging.JSONLogger(),
+		Now:    fakeNow(),
+	})
+	logged.ServeHTTP(
+		httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var record map[string]interface{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal("-", record["user"])
+	assert.Equal("-", record["request_id"])
+	assert.Equal(404.0, record["status"])
+}
+
+func TestCommonLoggerRequestId(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	var idInHandler string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.SetRequestId(r, "abc123")
+		idInHandler = logging.GetRequestId(r)
+		w.Write([]byte("hi"))
+	})
+	logged := weblogs.HandlerWithOptions(handler, &weblogs.Options{
+		Writer: &buf,
+		Logger: logging.ApacheCommonLoggerWithLatency(),
+		Now:    fakeNow(),
+	})
+	r := httptest.NewRequest("GET", "/home", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	logged.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal("abc123", idInHandler)
+	assert.Equal(
+		"10.0.0.1 - - [14/Nov/2013:10:30:00 +0000] \"GET /home HTTP/1.1\" 200 2 250 abc123\n",
+		buf.String())
+}
+
+func TestCommonLoggerNoRequestId(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	var idInHandler string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idInHandler = logging.GetRequestId(r)
+		w.Write([]byte("hi"))
+	})
+	logged := weblogs.HandlerWithOptions(handler, &weblogs.Options{
+		Writer: &buf,
+		Logger: logging.ApacheCommonLoggerWithLatency(),
+		Now:    fakeNow(),
+	})
+	r := httptest.NewRequest("GET", "/home", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	logged.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal("", idInHandler)
+	assert.Equal(
+		"10.0.0.1 - - [14/Nov/2013:10:30:00 +0000] \"GET /home HTTP/1.1\" 200 2 250 -\n",
+		buf.String())
+}
+
+func TestSampled(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	logger := logging.Sampled(
+		logging.ApacheCommonLoggerWithLatency(),
+		func(log *weblogs.LogRecord) bool {
+			return log.W.(*loggers.Capture).Status() != http.StatusOK
+		})
+	logged := weblogs.HandlerWithOptions(handler, &weblogs.Options{
+		Writer: &buf,
+		Logger: logger,
+		Now:    fakeNow(),
+	})
+	for _, target := range []string{"/healthz", "/healthz?fail=1", "/healthz"} {
+		logged.ServeHTTP(
+			httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 1)
+	assert.Contains(lines[0], "\"GET /healthz?fail=1 HTTP/1.1\" 503 0")
+}
+
+func TestWithValues(t *testing.T) {
+	assert := assert.New(t)
+	r := logging.WithValues(httptest.NewRequest("GET", "/", nil))
+	assert.Equal("", logging.GetUserName(r))
+	logging.SetUserName(r, "alice")
+	logging.SetRequestId(r, "xyz")
+	assert.Equal("alice", logging.GetUserName(r))
+	assert.Equal("xyz", logging.GetRequestId(r))
+}
+
+func TestWithoutValues(t *testing.T) {
+	assert := assert.New(t)
+	r := httptest.NewRequest("GET", "/", nil)
+	logging.SetUserName(r, "alice")
+	assert.Equal("", logging.GetUserName(r))
+}
+
+// fakeNow returns a function that returns 10:30 on the first call and
+// 250ms later on each subsequent call.
+func fakeNow() func() time.Time {
+	now := time.Date(2013, 11, 14, 10, 30, 0, 0, time.UTC)
+	return func() time.Time {
+		result := now
+		now = now.Add(250 * time.Millisecond)
+		return result
+	}
+}
@@ -1,11 +1,36 @@
-// Package mailer sends emails via gmail asynchronously.
+// Package mailer sends emails asynchronously via gmail or another SMTP
+// server.
 package mailer
 
 import (
+	"errors"
 	"fmt"
+	htemplate "html/template"
+	"io"
 	"log"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kGmailHost = "smtp.gmail.com"
+	kGmailPort = 587
+)
+
+var (
+	// ErrShutdown indicates that an email was not sent because the
+	// Mailer was shut down.
+	ErrShutdown = errors.New("mailer: Mailer shut down")
+
+	// ErrQueueFull indicates that TrySend did not send an email because
+	// too many emails were already waiting to be sent.
+	ErrQueueFull = errors.New("mailer: queue full")
 )
 
 // Email represents a single email.
@@ -13,26 +38,278 @@ type Email struct {
 	To      []string
 	Subject string
 	Body    string
+
+	// Optional carbon copy recipients
+	Cc []string
+
+	// Optional blind carbon copy recipients. These never appear in the
+	// headers of the email.
+	Bcc []string
+
+	// Optional address for replies
+	ReplyTo string
+
+	// If true, Body is HTML instead of plain text.
+	HTML bool
+
+	// Optional additional headers
+	Headers map[string]string
 }
 
 func (e *Email) toAddresses() string {
 	return strings.Join(e.To, ", ")
 }
 
-// Mailer sends emails asynchronously via gmail.
+// domains returns the lower case domains of the To addresses of e
+// without duplicates.
+func (e *Email) domains() []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, to := range e.To {
+		if addr, err := mail.ParseAddress(to); err == nil {
+			to = addr.Address
+		}
+		domain := strings.ToLower(to[strings.LastIndexByte(to, '@')+1:])
+		if !seen[domain] {
+			seen[domain] = true
+			result = append(result, domain)
+		}
+	}
+	return result
+}
+
+func (e *Email) recipients() []string {
+	result := make([]string, 0, len(e.To)+len(e.Cc)+len(e.Bcc))
+	result = append(result, e.To...)
+	result = append(result, e.Cc...)
+	return append(result, e.Bcc...)
+}
+
+// validate returns an error if a header of e contains a line break or
+// if e has a header name that is not a valid RFC 5322 field name.
+// validate keeps callers from injecting headers into the message.
+func (e *Email) validate() error {
+	for _, addrs := range [][]string{e.To, e.Cc, e.Bcc} {
+		for _, addr := range addrs {
+			if err := checkHeaderValue("address", addr); err != nil {
+				return err
+			}
+		}
+	}
+	if err := checkHeaderValue("Reply-To", e.ReplyTo); err != nil {
+		return err
+	}
+	if err := checkHeaderValue("Subject", e.Subject); err != nil {
+		return err
+	}
+	for name, value := range e.Headers {
+		if !isFieldName(name) {
+			return fmt.Errorf("mailer: invalid header name %q", name)
+		}
+		if err := checkHeaderValue(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkHeaderValue(name, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("mailer: line break in %s %q", name, value)
+	}
+	return nil
+}
+
+// isFieldName returns true if name consists of one or more printable
+// ASCII characters other than colon as RFC 5322 requires.
+func isFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 || name[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Email) message(from string) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From: %s\n", from)
+	fmt.Fprintf(&sb, "To: %s\n", e.toAddresses())
+	if len(e.Cc) > 0 {
+		fmt.Fprintf(&sb, "Cc: %s\n", strings.Join(e.Cc, ", "))
+	}
+	if e.ReplyTo != "" {
+		fmt.Fprintf(&sb, "Reply-To: %s\n", e.ReplyTo)
+	}
+	fmt.Fprintf(&sb, "Subject: %s\n", e.Subject)
+	if e.HTML {
+		sb.WriteString("MIME-Version: 1.0\n")
+		sb.WriteString("Content-Type: text/html; charset=\"UTF-8\"\n")
+	}
+	names := make([]string, 0, len(e.Headers))
+	for name := range e.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s: %s\n", name, e.Headers[name])
+	}
+	sb.WriteString("\n")
+	sb.WriteString(e.Body)
+	return []byte(sb.String())
+}
+
+// ShutdownError is returned by ShutdownTimeout when it times out.
+type ShutdownError struct {
+
+	// The emails that were not sent
+	Undelivered []Email
+}
+
+func (e *ShutdownError) Error() string {
+	parts := make([]string, len(e.Undelivered))
+	for i := range e.Undelivered {
+		parts[i] = fmt.Sprintf(
+			"(To: %s; Subject: %s)",
+			e.Undelivered[i].toAddresses(),
+			e.Undelivered[i].Subject)
+	}
+	return fmt.Sprintf(
+		"mailer: %d undelivered emails: %s",
+		len(parts),
+		strings.Join(parts, ", "))
+}
+
+// Option represents an optional setting for NewWithOptions.
+type Option func(m *Mailer)
+
+// SMTPServer makes the Mailer send emails through the SMTP server at
+// host and port instead of gmail. The Mailer uses STARTTLS if the
+// server supports it.
+func SMTPServer(host string, port int) Option {
+	return func(m *Mailer) {
+		m.host = host
+		m.port = port
+	}
+}
+
+// Auth makes the Mailer authenticate with auth instead of plain
+// authentication with the sender address and password. A nil auth means
+// no authentication.
+func Auth(auth smtp.Auth) Option {
+	return func(m *Mailer) {
+		m.auth = auth
+		m.authSet = true
+	}
+}
+
+// SendFunc makes the Mailer use f to send emails instead of smtp.SendMail.
+// f has the same signature as smtp.SendMail. SendFunc is useful for
+// testing.
+func SendFunc(
+	f func(
+		addr string,
+		a smtp.Auth,
+		from string,
+		to []string,
+		msg []byte) error) Option {
+	return func(m *Mailer) {
+		m.sendFunc = f
+	}
+}
+
+// RetryPolicy makes the Mailer try to send each email up to attempts
+// times waiting backoff between attempts. Only the error from the last
+// attempt is reported. The default is one attempt with no retries.
+func RetryPolicy(attempts int, backoff time.Duration) Option {
+	return func(m *Mailer) {
+		m.attempts = attempts
+		m.backoff = backoff
+	}
+}
+
+// DeadLetter makes the Mailer call f with each email it gives up on
+// along with the error from the last attempt to send it. The Mailer calls
+// f in a separate goroutine so that f does not delay other emails. f is
+// not called for emails ShutdownTimeout reports as undelivered.
+func DeadLetter(f func(email Email, err error)) Option {
+	return func(m *Mailer) {
+		m.deadLetter = f
+	}
+}
+
+// DomainPacing makes the Mailer wait at least interval between sending
+// emails to the same recipient domain. The domains of an email come from
+// its To addresses. While an email waits for its domain, the Mailer
+// continues sending emails to other domains. Emails to the same domain
+// are sent in the order they were queued.
+func DomainPacing(interval time.Duration) Option {
+	return func(m *Mailer) {
+		m.domainInterval = interval
+	}
+}
+
+// Mailer sends emails asynchronously via gmail or another SMTP server.
 type Mailer struct {
-	emailCh  chan Email
-	emailId  string
-	password string
+	emailCh        chan job
+	done           chan struct{}
+	abort          chan struct{}
+	abortOnce      sync.Once
+	closing        chan struct{}
+	closingOnce    sync.Once
+	closeMu        sync.RWMutex
+	closed         bool
+	mu             sync.Mutex
+	inFlight       *job
+	deferred       []job
+	emailId        string
+	password       string
+	host           string
+	port           int
+	auth           smtp.Auth
+	authSet        bool
+	attempts       int
+	backoff        time.Duration
+	domainInterval time.Duration
+	pacer          pacer
+	lastSend       map[string]time.Time
+	deadLetter     func(email Email, err error)
+	sendFunc       func(
+		addr string, a smtp.Auth, from string, to []string, msg []byte) error
 }
 
 // New creates a new instance. emailId and password are the gmail
 // sender address and password respectively.
 func New(emailId, password string) *Mailer {
+	return NewWithOptions(emailId, password)
+}
+
+// NewWithOptions works like New except that options can change the
+// SMTP server and the authentication used.
+func NewWithOptions(
+	emailId, password string, options ...Option) *Mailer {
 	result := &Mailer{
-		emailCh:  make(chan Email, 100),
+		emailCh:  make(chan job, 100),
+		done:     make(chan struct{}),
+		abort:    make(chan struct{}),
+		closing:  make(chan struct{}),
 		emailId:  emailId,
 		password: password,
+		host:     kGmailHost,
+		port:     kGmailPort,
+		attempts: 1,
+		pacer:    systemPacer{},
+		lastSend: make(map[string]time.Time),
+		sendFunc: smtp.SendMail,
+	}
+	for _, option := range options {
+		option(result)
+	}
+	if !result.authSet {
+		result.auth = smtp.PlainAuth("", emailId, password, result.host)
 	}
 	go result.loop()
 	return result
@@ -40,27 +317,396 @@ func New(emailId, password string) *Mailer {
 
 // Send sends one email asynchronously returning immediately. When it
 // eventually sends the email, it reports any errors to stderr.
+// If a header of email contains a line break or has an invalid name,
+// Send reports the error to stderr without sending the email. If too
+// many emails are waiting to be sent, Send waits for room. After
+// shutdown, Send reports ErrShutdown to stderr.
 func (m *Mailer) Send(email Email) {
-	m.emailCh <- email
+	if err := m.submit(email, true); err != nil {
+		log.Println(err)
+	}
+}
+
+// TrySend works like Send except that it returns errors instead of
+// reporting them to stderr and that it never waits. If too many emails
+// are waiting to be sent, TrySend returns ErrQueueFull without sending
+// email. Errors from eventually sending email still go to stderr.
+func (m *Mailer) TrySend(email Email) error {
+	return m.submit(email, false)
+}
+
+func (m *Mailer) submit(email Email, wait bool) error {
+	if err := email.validate(); err != nil {
+		return err
+	}
+	return m.enqueue(job{email: email}, wait)
+}
+
+// Template produces the body of an email. *template.Template from both
+// text/template and html/template implement Template.
+type Template interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// SendTemplate works like Send except that it produces the body of the
+// email by executing tmpl with data. If tmpl is an html/template, the
+// email is sent as HTML. SendTemplate returns any error from executing
+// tmpl or from checking the headers without sending the email.
+func (m *Mailer) SendTemplate(
+	tmpl Template, data interface{}, to []string, subject string) error {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return err
+	}
+	_, isHTML := tmpl.(*htemplate.Template)
+	email := Email{To: to, Subject: subject, Body: sb.String(), HTML: isHTML}
+	if err := email.validate(); err != nil {
+		return err
+	}
+	m.Send(email)
+	return nil
+}
+
+// SendFuture sends one email asynchronously returning immediately.
+// The returned channel receives the result of sending the email: nil
+// on success or the error encountered. If too many emails are waiting
+// to be sent, SendFuture waits for room. After shutdown, the returned
+// channel receives ErrShutdown. If email fails the checks that Send
+// makes, the returned channel receives that error.
+func (m *Mailer) SendFuture(email Email) <-chan error {
+	result := make(chan error, 1)
+	if err := email.validate(); err != nil {
+		result <- err
+	} else if err := m.enqueue(
+		job{email: email, result: result}, true); err != nil {
+		result <- err
+	}
+	return result
+}
+
+// PendingCount returns the number of emails waiting to be sent.
+func (m *Mailer) PendingCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.emailCh) + len(m.deferred)
+}
+
+// Shutdown sends all pending emails and then stops this instance.
+// Shutdown blocks until all pending emails are sent. After Shutdown,
+// this instance no longer accepts emails.
+func (m *Mailer) Shutdown() {
+	m.close()
+	<-m.done
+}
+
+// ShutdownTimeout works like Shutdown except that it waits at most d for
+// pending emails to be sent. If d elapses first, ShutdownTimeout stops
+// sending emails and returns a *ShutdownError listing the undelivered
+// emails. The list includes the email being sent when d elapsed.
+func (m *Mailer) ShutdownTimeout(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	m.close()
+	select {
+	case <-m.done:
+		return nil
+	case <-timer.C:
+	}
+	m.abortOnce.Do(func() { close(m.abort) })
+
+	// loop gives up on the email it is sending when abort closes, so
+	// waiting for it is quick.
+	<-m.done
+	m.mu.Lock()
+	pending := m.deferred
+	if m.inFlight != nil {
+		pending = append([]job{*m.inFlight}, pending...)
+	}
+	m.inFlight = nil
+	m.deferred = nil
+	m.mu.Unlock()
+	for j := range m.emailCh {
+		pending = append(pending, j)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	undelivered := make([]Email, len(pending))
+	for i, j := range pending {
+		undelivered[i] = j.email
+		if j.result != nil {
+			j.result <- ErrShutdown
+		}
+	}
+	return &ShutdownError{Undelivered: undelivered}
+}
+
+// enqueue adds j to the queue. If the queue is full, enqueue waits for
+// room if wait is true and returns ErrQueueFull otherwise. A waiting
+// enqueue gives up with ErrShutdown as soon as close is called so that
+// close never waits behind it.
+func (m *Mailer) enqueue(j job, wait bool) error {
+	m.closeMu.RLock()
+	defer m.closeMu.RUnlock()
+	if m.closed {
+		return ErrShutdown
+	}
+	if !wait {
+		select {
+		case m.emailCh <- j:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+	select {
+	case m.emailCh <- j:
+		return nil
+	case <-m.closing:
+		return ErrShutdown
+	}
+}
+
+func (m *Mailer) close() {
+	m.closingOnce.Do(func() { close(m.closing) })
+	m.closeMu.Lock()
+	defer m.closeMu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.emailCh)
+	}
 }
 
 func (m *Mailer) loop() {
-	auth := smtp.PlainAuth("", m.emailId, m.password, "smtp.gmail.com")
+	defer close(m.done)
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	if m.domainInterval > 0 {
+		m.pacedLoop(addr)
+		return
+	}
 	for {
-		email := <-m.emailCh
-		msgTemplate := "From: %s\n" +
-			"To: %s\n" +
-			"Subject: %s\n\n%s"
-		msg := fmt.Sprintf(
-			msgTemplate,
-			m.emailId,
-			email.toAddresses(),
-			email.Subject,
-			email.Body)
-		err := smtp.SendMail(
-			"smtp.gmail.com:587", auth, m.emailId, email.To, []byte(msg))
-		if err != nil {
-			log.Println(err)
+		select {
+		case j, ok := <-m.emailCh:
+			if !ok {
+				return
+			}
+			m.setInFlight(&j)
+			if !m.process(addr, j) {
+				return
+			}
+		case <-m.abort:
+			return
 		}
 	}
 }
+
+// pacedLoop works like loop except that it defers emails to domains
+// that were sent to less than domainInterval ago.
+func (m *Mailer) pacedLoop(addr string) {
+	emailCh := m.emailCh
+	for {
+		wait, hasDeferred := m.nextDeferredWait()
+		if emailCh == nil && !hasDeferred {
+			return
+		}
+		var timerC <-chan time.Time
+		var stopTimer func() bool
+		if hasDeferred {
+			timerC, stopTimer = m.pacer.NewTimer(wait)
+		}
+		select {
+		case j, ok := <-emailCh:
+			if !ok {
+				emailCh = nil
+			} else {
+				m.deferJob(j)
+			}
+		case <-timerC:
+		case <-m.abort:
+			return
+		}
+		if stopTimer != nil {
+			stopTimer()
+		}
+		if !m.sendReady(addr) {
+			return
+		}
+	}
+}
+
+// deferJob adds j to the end of the deferred emails.
+func (m *Mailer) deferJob(j job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deferred = append(m.deferred, j)
+}
+
+// sendReady sends the deferred emails whose domains are ready in the
+// order they were queued. An email whose domain is not ready holds back
+// later emails to the same domain. sendReady returns false if the
+// Mailer was aborted.
+func (m *Mailer) sendReady(addr string) bool {
+	blocked := make(map[string]bool)
+	for i := 0; ; {
+		select {
+		case <-m.abort:
+			return false
+		default:
+		}
+		m.mu.Lock()
+		if i >= len(m.deferred) {
+			m.mu.Unlock()
+			return true
+		}
+		j := m.deferred[i]
+		domains := j.email.domains()
+		if m.isBlocked(domains, blocked) {
+			for _, domain := range domains {
+				blocked[domain] = true
+			}
+			m.mu.Unlock()
+			i++
+			continue
+		}
+		m.deferred = append(m.deferred[:i:i], m.deferred[i+1:]...)
+		m.inFlight = &j
+		m.mu.Unlock()
+		now := m.pacer.Now()
+		for _, domain := range domains {
+			m.lastSend[domain] = now
+		}
+		if !m.process(addr, j) {
+			return false
+		}
+	}
+}
+
+func (m *Mailer) isBlocked(domains []string, blocked map[string]bool) bool {
+	for _, domain := range domains {
+		if blocked[domain] || m.domainWait(domain) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// nextDeferredWait returns how long until the earliest deferred email may
+// be sent and true. If there are no deferred emails, nextDeferredWait
+// returns false.
+func (m *Mailer) nextDeferredWait() (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.deferred) == 0 {
+		return 0, false
+	}
+	var result time.Duration
+	for i, j := range m.deferred {
+		var wait time.Duration
+		for _, domain := range j.email.domains() {
+			if w := m.domainWait(domain); w > wait {
+				wait = w
+			}
+		}
+		if i == 0 || wait < result {
+			result = wait
+		}
+	}
+	return result, true
+}
+
+func (m *Mailer) domainWait(domain string) time.Duration {
+	last, ok := m.lastSend[domain]
+	if !ok {
+		return 0
+	}
+	return last.Add(m.domainInterval).Sub(m.pacer.Now())
+}
+
+// process sends j and reports the result. If the Mailer is aborted
+// first, process leaves j in flight for ShutdownTimeout to report and
+// returns false.
+func (m *Mailer) process(addr string, j job) bool {
+	err := m.sendWithRetries(addr, &j.email)
+	if err == ErrShutdown {
+		return false
+	}
+	m.setInFlight(nil)
+	if err != nil && m.deadLetter != nil {
+		go m.deadLetter(j.email, err)
+	}
+	if j.result != nil {
+		j.result <- err
+	} else if err != nil {
+		log.Println(err)
+	}
+	return true
+}
+
+func (m *Mailer) setInFlight(j *job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight = j
+}
+
+// sendWithRetries returns ErrShutdown if the Mailer is aborted before
+// email is sent.
+func (m *Mailer) sendWithRetries(addr string, email *Email) error {
+	err := m.sendOrAbort(addr, email)
+	for i := 1; i < m.attempts && err != nil && err != ErrShutdown; i++ {
+		select {
+		case <-time.After(m.backoff):
+		case <-m.abort:
+			return ErrShutdown
+		}
+		err = m.sendOrAbort(addr, email)
+	}
+	return err
+}
+
+// sendOrAbort works like send except that it returns ErrShutdown as soon
+// as the Mailer is aborted. The abandoned send finishes in the
+// background.
+func (m *Mailer) sendOrAbort(addr string, email *Email) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- m.send(addr, email)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-m.abort:
+		return ErrShutdown
+	}
+}
+
+func (m *Mailer) send(addr string, email *Email) error {
+	return m.sendFunc(
+		addr,
+		m.auth,
+		m.emailId,
+		email.recipients(),
+		email.message(m.emailId))
+}
+
+// pacer supplies the current time and the timers for DomainPacing so
+// that tests can control them.
+type pacer interface {
+	Now() time.Time
+	NewTimer(d time.Duration) (c <-chan time.Time, stop func() bool)
+}
+
+type systemPacer struct{}
+
+func (systemPacer) Now() time.Time {
+	return time.Now()
+}
+
+func (systemPacer) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(d)
+	return timer.C, timer.Stop
+}
+
+type job struct {
+	email  Email
+	result chan error
+}
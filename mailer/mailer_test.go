@@ -0,0 +1,484 @@
+package mailer_test
+
+import (
+	"errors"
+	htemplate "html/template"
+	"net/smtp"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/keep94/toolbox/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errSend = errors.New("mailer_test: send failed")
+)
+
+func TestDefaultServer(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@gmail.com", "secret", mailer.SendFunc(sender.send))
+	m.Send(newEmail())
+	m.Shutdown()
+	sent := sender.Sent()
+	assert.Len(sent, 1)
+	assert.Equal("smtp.gmail.com:587", sent[0].addr)
+	assert.Equal("me@gmail.com", sent[0].from)
+	assert.Equal([]string{"you@example.com"}, sent[0].to)
+	assert.NotNil(sent[0].auth)
+}
+
+func TestSMTPServer(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@example.com",
+		"",
+		mailer.SMTPServer("localhost", 1025),
+		mailer.Auth(nil),
+		mailer.SendFunc(sender.send))
+	m.Send(newEmail())
+	m.Shutdown()
+	sent := sender.Sent()
+	assert.Len(sent, 1)
+	assert.Equal("localhost:1025", sent[0].addr)
+	assert.Nil(sent[0].auth)
+	assert.Equal(
+		"From: me@example.com\nTo: you@example.com\nSubject: Hi\n\nHello",
+		string(sent[0].msg))
+}
+
+func TestSendFuture(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	defer m.Shutdown()
+	assert.Nil(<-m.SendFuture(newEmail()))
+	assert.Len(sender.Sent(), 1)
+}
+
+func TestSendFutureError(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{err: errSend}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	defer m.Shutdown()
+	assert.Equal(errSend, <-m.SendFuture(newEmail()))
+}
+
+func TestShutdownDrainsQueue(t *testing.T) {
+	assert := assert.New(t)
+	unblock := make(chan struct{})
+	sender := &fakeSender{unblock: unblock}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	for i := 0; i < 5; i++ {
+		m.Send(newEmail())
+	}
+	close(unblock)
+	m.Shutdown()
+	assert.Len(sender.Sent(), 5)
+}
+
+func TestHTMLAndHeaders(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	email := mailer.Email{
+		To:      []string{"you@example.com"},
+		Cc:      []string{"cc1@example.com", "cc2@example.com"},
+		Bcc:     []string{"hidden@example.com"},
+		ReplyTo: "noreply@example.com",
+		Subject: "Hi",
+		Body:    "<b>Hello</b>",
+		HTML:    true,
+		Headers: map[string]string{"X-Priority": "1", "X-App": "toolbox"},
+	}
+	assert.Nil(<-m.SendFuture(email))
+	m.Shutdown()
+	sent := sender.Sent()
+	assert.Len(sent, 1)
+	assert.Equal(
+		[]string{
+			"you@example.com",
+			"cc1@example.com",
+			"cc2@example.com",
+			"hidden@example.com",
+		},
+		sent[0].to)
+	expected := "From: me@example.com\n" +
+		"To: you@example.com\n" +
+		"Cc: cc1@example.com, cc2@example.com\n" +
+		"Reply-To: noreply@example.com\n" +
+		"Subject: Hi\n" +
+		"MIME-Version: 1.0\n" +
+		"Content-Type: text/html; charset=\"UTF-8\"\n" +
+		"X-App: toolbox\n" +
+		"X-Priority: 1\n" +
+		"\n" +
+		"<b>Hello</b>"
+	assert.Equal(expected, string(sent[0].msg))
+	assert.NotContains(string(sent[0].msg), "hidden@example.com")
+}
+
+func TestHeaderInjection(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	injected := "Hi\r\nBcc: victim@example.com"
+	emails := []mailer.Email{
+		{To: []string{"you@example.com"}, Subject: injected},
+		{To: []string{"you@example.com\nBcc: victim@example.com"}},
+		{To: []string{"you@example.com"}, Cc: []string{injected}},
+		{To: []string{"you@example.com"}, ReplyTo: injected},
+		{
+			To:      []string{"you@example.com"},
+			Headers: map[string]string{"X-App": injected},
+		},
+		{
+			To:      []string{"you@example.com"},
+			Headers: map[string]string{"Bcc: victim@example.com\nX-App": "1"},
+		},
+		{
+			To:      []string{"you@example.com"},
+			Headers: map[string]string{"X-App:": "1"},
+		},
+		{
+			To:      []string{"you@example.com"},
+			Headers: map[string]string{"": "1"},
+		},
+	}
+	for _, email := range emails {
+		assert.Error(m.TrySend(email))
+		assert.Error(<-m.SendFuture(email))
+
+		// Send reports the error to stderr and drops the email
+		m.Send(email)
+	}
+	assert.Nil(m.TrySend(newEmail()))
+	m.Shutdown()
+	assert.Len(sender.Sent(), 1)
+	assert.Equal(mailer.ErrShutdown, m.TrySend(newEmail()))
+}
+
+func TestPendingCount(t *testing.T) {
+	assert := assert.New(t)
+	unblock := make(chan struct{})
+	sender := &fakeSender{unblock: unblock, started: make(chan struct{}, 10)}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	assert.Equal(0, m.PendingCount())
+	m.Send(newEmail())
+	<-sender.started
+	m.Send(newEmail())
+	m.Send(newEmail())
+	assert.Equal(2, m.PendingCount())
+	close(unblock)
+	m.Shutdown()
+	assert.Equal(0, m.PendingCount())
+}
+
+func TestShutdownTimeoutDrains(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{delay: 5 * time.Millisecond}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	for i := 0; i < 3; i++ {
+		m.Send(newEmail())
+	}
+	assert.Nil(m.ShutdownTimeout(time.Second))
+	assert.Len(sender.Sent(), 3)
+	assert.Equal(mailer.ErrShutdown, <-m.SendFuture(newEmail()))
+}
+
+func TestShutdownTimeoutExpires(t *testing.T) {
+	assert := assert.New(t)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	sender := &fakeSender{unblock: unblock, started: make(chan struct{}, 10)}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	m.Send(newEmail())
+	<-sender.started
+	future := m.SendFuture(newEmail())
+	m.Send(newEmail())
+	err := m.ShutdownTimeout(10 * time.Millisecond)
+	shutdownErr, ok := err.(*mailer.ShutdownError)
+	assert.True(ok)
+	assert.Len(shutdownErr.Undelivered, 3)
+	assert.Contains(err.Error(), "3 undelivered emails")
+	assert.Equal(mailer.ErrShutdown, <-future)
+}
+
+func TestShutdownTimeoutAbortsBackoff(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{err: errSend}
+	m := mailer.NewWithOptions(
+		"me@example.com",
+		"secret",
+		mailer.SendFunc(sender.send),
+		mailer.RetryPolicy(2, time.Hour))
+	future := m.SendFuture(newEmail())
+	assert.Eventually(func() bool {
+		return len(sender.Sent()) == 1
+	}, time.Second, time.Millisecond)
+	start := time.Now()
+	err := m.ShutdownTimeout(10 * time.Millisecond)
+	assert.True(time.Since(start) < time.Second)
+	shutdownErr, ok := err.(*mailer.ShutdownError)
+	assert.True(ok)
+	assert.Len(shutdownErr.Undelivered, 1)
+	assert.Equal(mailer.ErrShutdown, <-future)
+}
+
+func TestQueueFull(t *testing.T) {
+	assert := assert.New(t)
+	unblock := make(chan struct{})
+	sender := &fakeSender{unblock: unblock, started: make(chan struct{}, 200)}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	m.Send(newEmail())
+	<-sender.started
+	for i := 0; i < 100; i++ {
+		assert.Nil(m.TrySend(newEmail()))
+	}
+	assert.Equal(mailer.ErrQueueFull, m.TrySend(newEmail()))
+
+	// SendFuture waits for room instead of dropping the email
+	sent := make(chan error, 1)
+	go func() {
+		sent <- <-m.SendFuture(newEmail())
+	}()
+	close(unblock)
+	assert.Nil(<-sent)
+	m.Shutdown()
+	assert.Len(sender.Sent(), 102)
+}
+
+func TestShutdownReleasesWaitingSend(t *testing.T) {
+	assert := assert.New(t)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	sender := &fakeSender{unblock: unblock, started: make(chan struct{}, 1)}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	m.Send(newEmail())
+	<-sender.started
+	for i := 0; i < 100; i++ {
+		assert.Nil(m.TrySend(newEmail()))
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- <-m.SendFuture(newEmail())
+	}()
+	err := m.ShutdownTimeout(10 * time.Millisecond)
+	shutdownErr, ok := err.(*mailer.ShutdownError)
+	assert.True(ok)
+	assert.Len(shutdownErr.Undelivered, 101)
+	assert.Equal(mailer.ErrShutdown, <-sent)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{err: errSend, failures: 2}
+	m := mailer.NewWithOptions(
+		"me@example.com",
+		"secret",
+		mailer.SendFunc(sender.send),
+		mailer.RetryPolicy(3, time.Millisecond))
+	defer m.Shutdown()
+	assert.Nil(<-m.SendFuture(newEmail()))
+	assert.Len(sender.Sent(), 3)
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{err: errSend}
+	m := mailer.NewWithOptions(
+		"me@example.com",
+		"secret",
+		mailer.SendFunc(sender.send),
+		mailer.RetryPolicy(3, time.Millisecond))
+	defer m.Shutdown()
+	assert.Equal(errSend, <-m.SendFuture(newEmail()))
+	assert.Len(sender.Sent(), 3)
+}
+
+func TestSendTemplate(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	tmpl := template.Must(template.New("welcome").Parse("Hello {{.Name}}"))
+	assert.Nil(m.SendTemplate(
+		tmpl,
+		map[string]string{"Name": "Bob"},
+		[]string{"you@example.com"},
+		"Hi"))
+	m.Shutdown()
+	sent := sender.Sent()
+	assert.Len(sent, 1)
+	assert.Equal(
+		"From: me@example.com\nTo: you@example.com\nSubject: Hi\n\nHello Bob",
+		string(sent[0].msg))
+}
+
+func TestSendTemplateHTML(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	tmpl := htemplate.Must(htemplate.New("welcome").Parse("<b>{{.}}</b>"))
+	assert.Nil(m.SendTemplate(
+		tmpl, "Bob & Alice", []string{"you@example.com"}, "Hi"))
+	m.Shutdown()
+	sent := sender.Sent()
+	assert.Len(sent, 1)
+	assert.Contains(string(sent[0].msg), "Content-Type: text/html")
+	assert.Contains(string(sent[0].msg), "<b>Bob &amp; Alice</b>")
+}
+
+func TestSendTemplateError(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@example.com", "secret", mailer.SendFunc(sender.send))
+	tmpl := template.Must(
+		template.New("welcome").Option("missingkey=error").Parse(
+			"Hello {{.Name}}"))
+	assert.Error(m.SendTemplate(
+		tmpl, map[string]string{}, []string{"you@example.com"}, "Hi"))
+	m.Shutdown()
+	assert.Empty(sender.Sent())
+}
+
+func TestDomainPacingShutdownTimeout(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{}
+	m := mailer.NewWithOptions(
+		"me@example.com",
+		"secret",
+		mailer.SendFunc(sender.send),
+		mailer.DomainPacing(time.Hour))
+	assert.Nil(<-m.SendFuture(emailTo("a@busy.com")))
+	future := m.SendFuture(emailTo("b@busy.com"))
+	assert.Eventually(func() bool {
+		return m.PendingCount() == 1
+	}, time.Second, time.Millisecond)
+	err := m.ShutdownTimeout(10 * time.Millisecond)
+	shutdownErr, ok := err.(*mailer.ShutdownError)
+	assert.True(ok)
+	assert.Len(shutdownErr.Undelivered, 1)
+	assert.Equal(mailer.ErrShutdown, <-future)
+	assert.Len(sender.Sent(), 1)
+}
+
+func TestDeadLetter(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{err: errSend}
+	type deadLetter struct {
+		email mailer.Email
+		err   error
+	}
+	deadLetters := make(chan deadLetter, 1)
+	m := mailer.NewWithOptions(
+		"me@example.com",
+		"secret",
+		mailer.SendFunc(sender.send),
+		mailer.RetryPolicy(2, time.Millisecond),
+		mailer.DeadLetter(func(email mailer.Email, err error) {
+			deadLetters <- deadLetter{email: email, err: err}
+		}))
+	defer m.Shutdown()
+	email := emailTo("a@example.com")
+	m.Send(email)
+	select {
+	case d := <-deadLetters:
+		assert.Equal(email, d.email)
+		assert.Equal(errSend, d.err)
+	case <-time.After(time.Second):
+		t.Fatal("Expected dead letter")
+	}
+	assert.Len(sender.Sent(), 2)
+}
+
+func TestDeadLetterDoesNotBlock(t *testing.T) {
+	assert := assert.New(t)
+	sender := &fakeSender{err: errSend, failures: 1}
+	unblock := make(chan struct{})
+	defer close(unblock)
+	m := mailer.NewWithOptions(
+		"me@example.com",
+		"secret",
+		mailer.SendFunc(sender.send),
+		mailer.DeadLetter(func(email mailer.Email, err error) {
+			<-unblock
+		}))
+	defer m.Shutdown()
+	assert.Equal(errSend, <-m.SendFuture(newEmail()))
+	assert.Nil(<-m.SendFuture(newEmail()))
+}
+
+func emailTo(to string) mailer.Email {
+	return mailer.Email{To: []string{to}, Subject: "Hi", Body: "Hello"}
+}
+
+func newEmail() mailer.Email {
+	return mailer.Email{
+		To:      []string{"you@example.com"},
+		Subject: "Hi",
+		Body:    "Hello",
+	}
+}
+
+type sentEmail struct {
+	at   time.Time
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+	msg  []byte
+}
+
+type fakeSender struct {
+	err      error
+	failures int // if non-zero, only the first failures sends return err
+	delay    time.Duration
+	unblock  chan struct{}
+	started  chan struct{}
+	mu       sync.Mutex
+	sent     []sentEmail
+}
+
+func (f *fakeSender) send(
+	addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	if f.started != nil {
+		f.started <- struct{}{}
+	}
+	if f.unblock != nil {
+		<-f.unblock
+	}
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(
+		f.sent,
+		sentEmail{at: time.Now(), addr: addr, auth: a, from: from, to: to, msg: msg})
+	if f.failures > 0 && len(f.sent) > f.failures {
+		return nil
+	}
+	return f.err
+}
+
+func (f *fakeSender) Sent() []sentEmail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sent
+}
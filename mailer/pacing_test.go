@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"net/smtp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainPacing(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	var sent []string
+	send := func(
+		addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, to[0])
+		return nil
+	}
+	p := &fakePacer{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	m := NewWithOptions(
+		"me@example.com",
+		"secret",
+		SendFunc(send),
+		DomainPacing(200*time.Millisecond),
+		withPacer(p))
+	first := m.SendFuture(emailTo("a@busy.com"))
+	second := m.SendFuture(emailTo("Bob <b@BUSY.com>"))
+	other := m.SendFuture(emailTo("c@quiet.com"))
+	assert.Nil(<-first)
+	assert.Nil(<-other)
+
+	// second waits for busy.com until the clock advances.
+	p.Advance(200 * time.Millisecond)
+	assert.Nil(<-second)
+	m.Shutdown()
+	assert.Equal(
+		[]string{"a@busy.com", "c@quiet.com", "Bob <b@BUSY.com>"}, sent)
+	sleeps := p.Sleeps()
+	assert.NotEmpty(sleeps)
+	assert.Equal(200*time.Millisecond, sleeps[0])
+	for _, sleep := range sleeps {
+		assert.True(sleep <= 200*time.Millisecond)
+	}
+}
+
+func emailTo(to string) Email {
+	return Email{To: []string{to}, Subject: "Hi", Body: "Hello"}
+}
+
+func withPacer(p pacer) Option {
+	return func(m *Mailer) {
+		m.pacer = p
+	}
+}
+
+// fakePacer records the duration of each timer. Its timers fire only
+// when Advance moves the clock past their deadlines.
+type fakePacer struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (f *fakePacer) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakePacer) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sleeps = append(f.sleeps, d)
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+	} else {
+		f.timers = append(f.timers, fakeTimer{deadline: f.now.Add(d), c: c})
+	}
+	return c, func() bool { return true }
+}
+
+func (f *fakePacer) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.deadline.After(f.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- f.now
+		}
+	}
+	f.timers = pending
+}
+
+func (f *fakePacer) Sleeps() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Duration(nil), f.sleeps...)
+}
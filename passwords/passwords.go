@@ -5,9 +5,23 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"golang.org/x/crypto/pbkdf2"
 	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultReps is the work factor that New uses.
+	DefaultReps = 4096
+)
+
+var (
+	// Verifying against kDummy does the same work as verifying against
+	// a real Password.
+	kDummy = New(base64.StdEncoding.EncodeToString(random(16)))
 )
 
 // Password is a one-way encryption of a password.
@@ -15,23 +29,102 @@ type Password string
 
 // New creates a new Password from a plain text password.
 func New(password string) Password {
-	salt := make([]byte, 8, 28)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		panic(err.Error())
+	return Password(encode(password, DefaultReps))
+}
+
+// NewWithReps works like New except that reps is the work factor, the
+// number of repititions to use to encrypt the password. The larger reps is,
+// the longer it takes to encrypt and verify the password. The returned
+// Password records reps. NewWithReps panics if reps is not positive.
+func NewWithReps(password string, reps int) Password {
+	if reps <= 0 {
+		panic("reps must be positive")
 	}
-	gen := pbkdf2.Key([]byte(password), salt, 4096, 20, sha1.New)
-	return Password(base64.StdEncoding.EncodeToString(append(salt, gen...)))
+	return Password(strconv.Itoa(reps) + "$" + encode(password, reps))
+}
+
+// NewWithPepper works like New except that it mixes pepper, an
+// application wide secret kept outside the database, into the password
+// before encrypting it. Passwords created with NewWithPepper verify only
+// with VerifyWithPepper and the same pepper.
+func NewWithPepper(password string, pepper []byte) Password {
+	return New(applyPepper(password, pepper))
 }
 
 // Verify returns true if the provided plain text password matches this instance.
 func (p Password) Verify(password string) bool {
-	bytes, err := base64.StdEncoding.DecodeString(string(p))
-	if err != nil {
+	reps, bytes, ok := p.decode()
+	if !ok {
 		return false
 	}
+	gen := pbkdf2.Key([]byte(password), bytes[:8], reps, 20, sha1.New)
+	return hmac.Equal(gen, bytes[8:])
+}
+
+// VerifyWithPepper returns true if the provided plain text password
+// matches this instance which was created with NewWithPepper and pepper.
+func (p Password) VerifyWithPepper(password string, pepper []byte) bool {
+	return p.Verify(applyPepper(password, pepper))
+}
+
+// DummyVerify does the same work as Verify and always returns false.
+// Login handlers should call DummyVerify with the attempted password when
+// there is no such user so that response times do not reveal which users
+// exist.
+func DummyVerify(attempt string) bool {
+	kDummy.Verify(attempt)
+	return false
+}
+
+// NeedsUpgrade returns true if this instance has a work factor less than
+// reps or is malformed. After a successful Verify, callers can replace
+// a Password needing an upgrade with NewWithReps(password, reps).
+func (p Password) NeedsUpgrade(reps int) bool {
+	storedReps, _, ok := p.decode()
+	if !ok {
+		return true
+	}
+	return storedReps < reps
+}
+
+func (p Password) decode() (reps int, bytes []byte, ok bool) {
+	reps = DefaultReps
+	encoded := string(p)
+	if idx := strings.IndexByte(encoded, '$'); idx != -1 {
+		var err error
+		reps, err = strconv.Atoi(encoded[:idx])
+		if err != nil || reps <= 0 {
+			return
+		}
+		encoded = encoded[idx+1:]
+	}
+	bytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return
+	}
 	if len(bytes) < 8 {
-		return false
+		return
 	}
-	gen := pbkdf2.Key([]byte(password), bytes[:8], 4096, 20, sha1.New)
-	return hmac.Equal(gen, bytes[8:])
+	return reps, bytes, true
+}
+
+// applyPepper returns the HMAC-SHA256 of password keyed with pepper.
+func applyPepper(password string, pepper []byte) string {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil))
+}
+
+func encode(password string, reps int) string {
+	salt := random(8)
+	gen := pbkdf2.Key([]byte(password), salt, reps, 20, sha1.New)
+	return base64.StdEncoding.EncodeToString(append(salt, gen...))
+}
+
+func random(count int) []byte {
+	result := make([]byte, count)
+	if _, err := io.ReadFull(rand.Reader, result); err != nil {
+		panic(err.Error())
+	}
+	return result
 }
@@ -26,3 +26,70 @@ func TestSalt(t *testing.T) {
 		t.Error("Hash should be different every time.")
 	}
 }
+
+func TestPepper(t *testing.T) {
+	pepper := []byte("pepper")
+	p := NewWithPepper("boo", pepper)
+	if !p.VerifyWithPepper("boo", pepper) {
+		t.Error("Password did not verify")
+	}
+	if p.VerifyWithPepper("foo", pepper) {
+		t.Error("Password should not have verified.")
+	}
+	if p.VerifyWithPepper("boo", []byte("salt")) {
+		t.Error("Password should not verify with a different pepper.")
+	}
+	if p.Verify("boo") {
+		t.Error("Password should not verify without pepper.")
+	}
+	if New("boo").VerifyWithPepper("boo", pepper) {
+		t.Error("Unpeppered password should not verify with pepper.")
+	}
+	var zero Password
+	if zero.VerifyWithPepper("boo", pepper) {
+		t.Error("Zero value of Password should not verify against anything.")
+	}
+}
+
+func TestNewWithReps(t *testing.T) {
+	p := NewWithReps("boo", 100)
+	if !p.Verify("boo") {
+		t.Error("Password did not verify")
+	}
+	if p.Verify("foo") {
+		t.Error("Password should not have verified.")
+	}
+}
+
+func TestNeedsUpgrade(t *testing.T) {
+	p := NewWithReps("boo", 100)
+	if p.NeedsUpgrade(100) {
+		t.Error("Password should not need upgrade at same cost.")
+	}
+	if !p.NeedsUpgrade(200) {
+		t.Error("Password should need upgrade at higher cost.")
+	}
+	p = New("boo")
+	if p.NeedsUpgrade(DefaultReps) {
+		t.Error("Password should not need upgrade at default cost.")
+	}
+	if !p.NeedsUpgrade(DefaultReps + 1) {
+		t.Error("Password should need upgrade at higher cost.")
+	}
+	var zero Password
+	if !zero.NeedsUpgrade(1) {
+		t.Error("Malformed password should need upgrade.")
+	}
+}
+
+func TestDummyVerify(t *testing.T) {
+	if _, _, ok := kDummy.decode(); !ok {
+		t.Error("Dummy password should be well formed.")
+	}
+	if DummyVerify("boo") {
+		t.Error("DummyVerify should always return false.")
+	}
+	if DummyVerify("") {
+		t.Error("DummyVerify should always return false.")
+	}
+}
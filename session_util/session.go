@@ -1,18 +1,28 @@
 // Package session_util provides support for managing web sessions for apps
 // where users logs in.
+//
+// session_util registers only its own session key type with gob. Apps
+// whose session store gob encodes values must register the value types
+// they store themselves. That includes time.Time when using LastLogin or
+// SetUserIdWithGrace and map[string]interface{} when using SetValue.
 package session_util
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base32"
+	"encoding/base64"
+	"encoding/gob"
 	"fmt"
 	"github.com/keep94/context"
 	"github.com/keep94/sessions"
+	"github.com/keep94/toolbox/date_util"
 	"github.com/keep94/toolbox/kdf"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -33,16 +43,36 @@ func (s UserIdSession) UserId() (int64, bool) {
 }
 
 // SetUserId sets the user ID in this session and generates a new xsrf secret
-// for creating xsrf tokens.
+// for creating xsrf tokens. SetUserId also discards any csrf token so that
+// CsrfToken mints a new one.
 func (s UserIdSession) SetUserId(id int64) {
 	s.S.Values[kUserIdKey] = id
 	s.setXsrfSecret(kdf.Random(64))
+	s.clearPrevXsrfSecret()
+	delete(s.S.Values, kCsrfTokenKey)
 }
 
-// ClearUserId clears the user ID in this session and clears any xsrf secret.
+// SetUserIdWithGrace works like SetUserId except that xsrf tokens created
+// with the previous xsrf secret keep verifying until graceEnd. This way,
+// forms already on the user's screen don't fail right after the user logs
+// in again. Only tokens for the same user Id verify with the previous
+// secret.
+func (s UserIdSession) SetUserIdWithGrace(id int64, graceEnd time.Time) {
+	prevSecret, hasPrev := s.xsrfSecret()
+	s.SetUserId(id)
+	if hasPrev {
+		s.S.Values[kPrevXsrfSecretKey] = prevSecret
+		s.S.Values[kPrevXsrfSecretEndKey] = graceEnd
+	}
+}
+
+// ClearUserId clears the user ID in this session and clears any xsrf secret
+// and csrf token.
 func (s UserIdSession) ClearUserId() {
 	delete(s.S.Values, kUserIdKey)
 	s.clearXsrfSecret()
+	s.clearPrevXsrfSecret()
+	delete(s.S.Values, kCsrfTokenKey)
 }
 
 // LastLogin returns the last login time and true if stored in this session;
@@ -65,6 +95,58 @@ func (s UserIdSession) ClearLastLogin() {
 	delete(s.S.Values, kLastLoginKey)
 }
 
+// SetValue stores v under key in this session. Keys passed to SetValue
+// never clash with the keys that UserIdSession uses internally. When
+// sessions are stored in cookies, v's type must be registered with
+// gob.Register.
+func (s UserIdSession) SetValue(key string, v interface{}) {
+	values, ok := s.userValues()
+	if !ok {
+		values = make(map[string]interface{})
+		s.S.Values[kUserValuesKey] = values
+	}
+	values[key] = v
+}
+
+// GetValue returns the value stored under key with SetValue and true.
+// If there is no such value, GetValue returns nil and false.
+func (s UserIdSession) GetValue(key string) (interface{}, bool) {
+	values, ok := s.userValues()
+	if !ok {
+		return nil, false
+	}
+	result, ok := values[key]
+	return result, ok
+}
+
+// DeleteValue deletes the value stored under key with SetValue.
+func (s UserIdSession) DeleteValue(key string) {
+	values, ok := s.userValues()
+	if !ok {
+		return
+	}
+	delete(values, key)
+	if len(values) == 0 {
+		delete(s.S.Values, kUserValuesKey)
+	}
+}
+
+// EstimatedSize returns the number of bytes in the gob encoding of this
+// session's values. Cookie based session stores gob encode values and
+// typically reject sessions exceeding 4KB after encryption and base64
+// encoding, so handlers can use EstimatedSize to warn before a session
+// fails to save. If the values can't be gob encoded, for instance because
+// a value's type wasn't registered with gob.Register, EstimatedSize
+// returns -1. See the package documentation for the types callers must
+// register.
+func (s UserIdSession) EstimatedSize() int {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(s.S.Values); err != nil {
+		return -1
+	}
+	return buffer.Len()
+}
+
 // ClearAll clears all data from this session including any xsrf secret.
 func (s UserIdSession) ClearAll() {
 	for key := range s.S.Values {
@@ -85,11 +167,7 @@ func (s UserIdSession) NewXsrfToken(action string, expire time.Time) string {
 		panic("No secret.")
 	}
 	expireUnix := expire.Unix()
-	mac := hmac.New(sha256.New, secret)
-	message := fmt.Sprintf("%d_%d_%s", expireUnix, userId, action)
-	mac.Write(([]byte)(message))
-	checksum := strings.TrimRight(
-		base32.StdEncoding.EncodeToString(mac.Sum(nil)), "=")
+	checksum := xsrfChecksum(secret, expireUnix, userId, action)
 	return fmt.Sprintf("%d:%s", expireUnix, checksum)
 }
 
@@ -118,12 +196,84 @@ func (s UserIdSession) VerifyXsrfToken(
 		return false
 	}
 	expectedChecksum := tokenToBeVerified[idx+1:]
+	checksum := xsrfChecksum(secret, expireUnix, userId, action)
+	if kdf.SecureCompareString(expectedChecksum, checksum) {
+		return true
+	}
+	prevSecret, ok := s.prevXsrfSecret(now)
+	if !ok {
+		return false
+	}
+	prevChecksum := xsrfChecksum(prevSecret, expireUnix, userId, action)
+	return kdf.SecureCompareString(expectedChecksum, prevChecksum)
+}
+
+func xsrfChecksum(
+	secret []byte, expireUnix, userId int64, action string) string {
 	mac := hmac.New(sha256.New, secret)
 	message := fmt.Sprintf("%d_%d_%s", expireUnix, userId, action)
 	mac.Write(([]byte)(message))
-	checksum := strings.TrimRight(
+	return strings.TrimRight(
 		base32.StdEncoding.EncodeToString(mac.Sum(nil)), "=")
-	return hmac.Equal(([]byte)(expectedChecksum), ([]byte)(checksum))
+}
+
+// CsrfToken returns the csrf token of this session for the double submit
+// cookie pattern minting a new random one if this session has none.
+// Unlike xsrf tokens, csrf tokens don't depend on a user being logged in.
+// The csrf token changes whenever the user logs in or out.
+func (s UserIdSession) CsrfToken() string {
+	result, ok := s.S.Values[kCsrfTokenKey].(string)
+	if !ok {
+		result = base64.RawURLEncoding.EncodeToString(kdf.Random(32))
+		s.S.Values[kCsrfTokenKey] = result
+	}
+	return result
+}
+
+// SetCsrfCookie stores the csrf token of this session in the cookie
+// cookieName so that javascript on the page can read it and echo it back
+// in a request header. Caller must save the session afterwards in case
+// SetCsrfCookie minted a new token.
+func (s UserIdSession) SetCsrfCookie(
+	w http.ResponseWriter, r *http.Request, cookieName string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    s.CsrfToken(),
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		Secure:   r.TLS != nil,
+	})
+}
+
+// VerifyCsrf returns true if the header headerName of r and the cookie
+// cookieName of r both match the csrf token of this session. VerifyCsrf
+// returns false if the header or cookie is missing or if this session
+// has no csrf token.
+func (s UserIdSession) VerifyCsrf(
+	r *http.Request, cookieName, headerName string) bool {
+	token, ok := s.S.Values[kCsrfTokenKey].(string)
+	if !ok {
+		return false
+	}
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return false
+	}
+	header := r.Header.Get(headerName)
+	if header == "" {
+		return false
+	}
+	headerMatches := kdf.SecureCompareString(header, cookie.Value)
+	cookieMatches := kdf.SecureCompareString(cookie.Value, token)
+	return headerMatches && cookieMatches
+}
+
+func (s UserIdSession) userValues() (map[string]interface{}, bool) {
+	result, ok := s.S.Values[kUserValuesKey]
+	if !ok {
+		return nil, false
+	}
+	return result.(map[string]interface{}), true
 }
 
 func (s UserIdSession) xsrfSecret() ([]byte, bool) {
@@ -134,6 +284,25 @@ func (s UserIdSession) xsrfSecret() ([]byte, bool) {
 	return result.([]byte), true
 }
 
+// recordLastLogin sets the last login time to now unless it was already
+// recorded for the current login. Since SetUserId generates a new xsrf
+// secret for each login, recordLastLogin identifies the login by a digest
+// of that secret.
+func (s UserIdSession) recordLastLogin(now time.Time) {
+	secret, ok := s.xsrfSecret()
+	if !ok {
+		return
+	}
+	digest := sha256.Sum256(secret)
+	login := digest[:8]
+	if recorded, ok := s.S.Values[kLastLoginRecordedKey].([]byte); ok &&
+		bytes.Equal(recorded, login) {
+		return
+	}
+	s.SetLastLogin(now)
+	s.S.Values[kLastLoginRecordedKey] = login
+}
+
 func (s UserIdSession) setXsrfSecret(secret []byte) {
 	s.S.Values[kXsrfSecretKey] = secret
 }
@@ -142,6 +311,22 @@ func (s UserIdSession) clearXsrfSecret() {
 	delete(s.S.Values, kXsrfSecretKey)
 }
 
+// prevXsrfSecret returns the previous xsrf secret and true if its grace
+// period has not ended as of now.
+func (s UserIdSession) prevXsrfSecret(now time.Time) ([]byte, bool) {
+	graceEnd, ok := s.S.Values[kPrevXsrfSecretEndKey].(time.Time)
+	if !ok || !now.Before(graceEnd) {
+		return nil, false
+	}
+	result, ok := s.S.Values[kPrevXsrfSecretKey].([]byte)
+	return result, ok
+}
+
+func (s UserIdSession) clearPrevXsrfSecret() {
+	delete(s.S.Values, kPrevXsrfSecretKey)
+	delete(s.S.Values, kPrevXsrfSecretEndKey)
+}
+
 type UserGetter interface {
 	// GetUser retrieves a user from persistent storage given user Id.
 	GetUser(id int64) (userPtr interface{}, err error)
@@ -157,11 +342,74 @@ type UserSession interface {
 	SetUser(userPtr interface{})
 }
 
+// Option represents an optional setting for NewUserSession.
+type Option func(o *userSessionOptions)
+
+// RecordLastLogin makes NewUserSession set the last login time of the
+// UserSession to the current time of clock when it loads a user for the
+// first time since the user logged in with SetUserId. Later loads leave
+// the last login time alone. A nil clock means the system clock. To use
+// RecordLastLogin, the UserSession must embed UserIdSession or
+// NewUserSession returns an error. The caller is responsible for saving
+// the session.
+func RecordLastLogin(clock date_util.Clock) Option {
+	return func(o *userSessionOptions) {
+		if clock == nil {
+			clock = date_util.SystemClock{}
+		}
+		o.lastLoginClock = clock
+	}
+}
+
+// LoadUserLazily makes NewUserSession defer loading the user until the
+// first call to User on the UserSession. This saves a trip to persistent
+// storage for requests that only need the user Id. To use LoadUserLazily,
+// the UserSession must embed LazyUser or NewUserSession returns an error.
+// When the user loads, SetUser is called on the UserSession and, if
+// RecordLastLogin is also given, the last login time is recorded. Since
+// that happens on the first call to User rather than within
+// NewUserSession, the caller must save the session after calling User
+// for the last login time to persist.
+func LoadUserLazily() Option {
+	return func(o *userSessionOptions) {
+		o.lazy = true
+	}
+}
+
+// LazyUser provides the User method to UserSession implementations that
+// NewUserSession creates with the LoadUserLazily option.
+type LazyUser struct {
+	once sync.Once
+	load func() (interface{}, error)
+	user interface{}
+	err  error
+}
+
+// User loads the logged in user the first time it is called and returns
+// it. Later calls return the same user without loading it again. If no
+// user is logged in, User returns nil and no error.
+func (l *LazyUser) User() (userPtr interface{}, err error) {
+	l.once.Do(func() {
+		if l.load != nil {
+			l.user, l.err = l.load()
+		}
+	})
+	return l.user, l.err
+}
+
+func (l *LazyUser) setUserLoader(load func() (interface{}, error)) {
+	l.load = load
+}
+
 // NewUserSession creates a new UserSession and pairs it with the current
 // http request.
-// If a user is logged in, the returned UserSession will contain
-// that user instance; otherwise returned UserSession will contain
-// nil for the user instance. Upon successful completion, caller must call
+// If a user is logged in, NewUserSession loads that user instance and
+// passes it to SetUser on the returned UserSession; otherwise the
+// returned UserSession will contain nil for the user instance. With the
+// LoadUserLazily option, NewUserSession does not load the user. Instead
+// the user loads and is passed to SetUser on the first call to User on
+// the returned UserSession.
+// Upon successful completion, caller must call
 // context.Clear(r) from github.com/gorilla/context.
 // sessionStore is the session store; r is the current http request;
 // cookieName is the name of the session cookie;
@@ -175,17 +423,55 @@ func NewUserSession(
 	cookieName string,
 	factory func(s *sessions.Session) UserSession,
 	userGetter UserGetter,
-	noSuchId error) (UserSession, error) {
+	noSuchId error,
+	options ...Option) (UserSession, error) {
+	var opts userSessionOptions
+	for _, option := range options {
+		option(&opts)
+	}
 	gs, err := sessionStore.Get(r, cookieName)
 	if err != nil {
 		return nil, err
 	}
 	result := factory(gs)
+	var loaderSetter userLoaderSetter
+	if opts.lazy {
+		var ok bool
+		loaderSetter, ok = result.(userLoaderSetter)
+		if !ok {
+			return nil, fmt.Errorf(
+				"session_util: LoadUserLazily requires %T to embed LazyUser",
+				result)
+		}
+	}
+	var recorder lastLoginRecorder
+	if opts.lastLoginClock != nil {
+		var ok bool
+		recorder, ok = result.(lastLoginRecorder)
+		if !ok {
+			return nil, fmt.Errorf(
+				"session_util: RecordLastLogin requires %T to embed UserIdSession",
+				result)
+		}
+	}
 	if userId, ok := result.UserId(); ok {
-		userPtr, err := userGetter.GetUser(userId)
-		if err == nil {
+		load := func() (interface{}, error) {
+			userPtr, err := userGetter.GetUser(userId)
+			if err == noSuchId {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
 			result.SetUser(userPtr)
-		} else if err != noSuchId {
+			if recorder != nil {
+				recorder.recordLastLogin(opts.lastLoginClock.Now())
+			}
+			return userPtr, nil
+		}
+		if loaderSetter != nil {
+			loaderSetter.setUserLoader(load)
+		} else if _, err := load(); err != nil {
 			return nil, err
 		}
 	}
@@ -197,7 +483,32 @@ func NewUserSession(
 // an error to call GetUserSession on a request without a previously
 // successful call to NewUserSession on the same request.
 func GetUserSession(r *http.Request) UserSession {
-	return context.Get(r, kSessionContextKey).(UserSession)
+	result, ok := TryGetUserSession(r)
+	if !ok {
+		panic("session_util: no UserSession paired with request")
+	}
+	return result
+}
+
+// TryGetUserSession returns the UserSession paired with this request and
+// true. If there was no previously successful call to NewUserSession on
+// the same request, TryGetUserSession returns nil and false.
+func TryGetUserSession(r *http.Request) (UserSession, bool) {
+	result, ok := context.Get(r, kSessionContextKey).(UserSession)
+	return result, ok
+}
+
+type userSessionOptions struct {
+	lastLoginClock date_util.Clock
+	lazy           bool
+}
+
+type userLoaderSetter interface {
+	setUserLoader(load func() (interface{}, error))
+}
+
+type lastLoginRecorder interface {
+	recordLastLogin(now time.Time)
 }
 
 type sessionKeyType int
@@ -206,8 +517,17 @@ const (
 	kUserIdKey sessionKeyType = iota
 	kXsrfSecretKey
 	kLastLoginKey
+	kUserValuesKey
+	kCsrfTokenKey
+	kPrevXsrfSecretKey
+	kPrevXsrfSecretEndKey
+	kLastLoginRecordedKey
 )
 
+func init() {
+	gob.Register(sessionKeyType(0))
+}
+
 type contextKeyType int
 
 const (
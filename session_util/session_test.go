@@ -1,14 +1,18 @@
 package session_util_test
 
 import (
+	"encoding/gob"
 	"errors"
 	"fmt"
 	"github.com/keep94/context"
 	"github.com/keep94/ramstore"
 	"github.com/keep94/sessions"
+	"github.com/keep94/toolbox/date_util"
 	"github.com/keep94/toolbox/session_util"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -95,6 +99,48 @@ func TestXsrfTokenNewUser(t *testing.T) {
 	}
 }
 
+func TestXsrfTokenGrace(t *testing.T) {
+	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
+	s.SetUserId(kUserId)
+	oldToken := s.NewXsrfToken("MyPage", kNow.Add(15*time.Minute))
+	s.SetUserIdWithGrace(kUserId, kNow.Add(time.Minute))
+	newToken := s.NewXsrfToken("MyPage", kNow.Add(15*time.Minute))
+	if oldToken == newToken {
+		t.Error("Expected secret to change.")
+	}
+	if !s.VerifyXsrfToken(oldToken, "MyPage", kNow.Add(59*time.Second)) {
+		t.Error("Expected old token to verify within grace period.")
+	}
+	if !s.VerifyXsrfToken(newToken, "MyPage", kNow.Add(59*time.Second)) {
+		t.Error("Expected new token to verify.")
+	}
+	if s.VerifyXsrfToken(oldToken, "AnotherPage", kNow) {
+		t.Error("Expected old token not to verify. Wrong page")
+	}
+	if s.VerifyXsrfToken(oldToken, "MyPage", kNow.Add(time.Minute)) {
+		t.Error("Expected old token not to verify after grace period.")
+	}
+	if !s.VerifyXsrfToken(newToken, "MyPage", kNow.Add(time.Minute)) {
+		t.Error("Expected new token to verify after grace period.")
+	}
+}
+
+func TestXsrfTokenGraceEndsOnLogout(t *testing.T) {
+	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
+	s.SetUserId(kUserId)
+	oldToken := s.NewXsrfToken("MyPage", kNow.Add(15*time.Minute))
+	s.SetUserIdWithGrace(kUserId, kNow.Add(time.Minute))
+	s.ClearUserId()
+	s.SetUserId(kUserId)
+	if s.VerifyXsrfToken(oldToken, "MyPage", kNow) {
+		t.Error("Expected old token not to verify. User logged out.")
+	}
+	s.SetUserIdWithGrace(kUserId+1, kNow.Add(time.Minute))
+	if s.VerifyXsrfToken(oldToken, "MyPage", kNow) {
+		t.Error("Expected old token not to verify. Different user.")
+	}
+}
+
 func TestXsrfTokenHack(t *testing.T) {
 	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
 	s.SetUserId(kUserId)
@@ -135,6 +181,55 @@ func TestXsrfTokenHack(t *testing.T) {
 	}
 }
 
+func TestCsrf(t *testing.T) {
+	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
+	w := httptest.NewRecorder()
+	s.SetCsrfCookie(w, httptest.NewRequest("GET", "/", nil), "csrf")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != s.CsrfToken() {
+		t.Fatalf("Expected csrf cookie, got %v", cookies)
+	}
+	if cookies[0].HttpOnly {
+		t.Error("Expected csrf cookie to be readable by javascript.")
+	}
+	token := s.CsrfToken()
+	if !s.VerifyCsrf(csrfRequest(token, token), "csrf", "X-CSRF-Token") {
+		t.Error("Expected csrf to verify.")
+	}
+	if s.VerifyCsrf(csrfRequest(token, "wrong"), "csrf", "X-CSRF-Token") {
+		t.Error("Expected csrf not to verify. Header mismatch.")
+	}
+	if s.VerifyCsrf(csrfRequest("wrong", "wrong"), "csrf", "X-CSRF-Token") {
+		t.Error("Expected csrf not to verify. Cookie mismatch.")
+	}
+	if s.VerifyCsrf(csrfRequest("", token), "csrf", "X-CSRF-Token") {
+		t.Error("Expected csrf not to verify. Missing cookie.")
+	}
+	if s.VerifyCsrf(csrfRequest(token, ""), "csrf", "X-CSRF-Token") {
+		t.Error("Expected csrf not to verify. Missing header.")
+	}
+}
+
+func TestCsrfRotates(t *testing.T) {
+	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
+	token := s.CsrfToken()
+	if token != s.CsrfToken() {
+		t.Error("Expected csrf token to be stable.")
+	}
+	s.SetUserId(kUserId)
+	if s.VerifyCsrf(csrfRequest(token, token), "csrf", "X-CSRF-Token") {
+		t.Error("Expected csrf not to verify. User logged in.")
+	}
+	token = s.CsrfToken()
+	s.ClearUserId()
+	if s.VerifyCsrf(csrfRequest(token, token), "csrf", "X-CSRF-Token") {
+		t.Error("Expected csrf not to verify. User logged out.")
+	}
+	if token == s.CsrfToken() {
+		t.Error("Expected csrf token to change.")
+	}
+}
+
 func TestSessionUserId(t *testing.T) {
 	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
 	s.SetUserId(kUserId)
@@ -169,6 +264,78 @@ func TestSessionUserId(t *testing.T) {
 
 }
 
+func TestSessionValues(t *testing.T) {
+	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
+	if _, ok := s.GetValue("locale"); ok {
+		t.Error("Did not expect a locale.")
+	}
+	s.SetValue("locale", "en_US")
+	s.SetValue("theme", "dark")
+	if v, ok := s.GetValue("locale"); !ok || v != "en_US" {
+		t.Errorf("Expected en_US, got %v", v)
+	}
+	s.DeleteValue("locale")
+	if _, ok := s.GetValue("locale"); ok {
+		t.Error("Did not expect a locale after delete.")
+	}
+	if v, ok := s.GetValue("theme"); !ok || v != "dark" {
+		t.Errorf("Expected dark, got %v", v)
+	}
+	s.DeleteValue("theme")
+	s.DeleteValue("not_there")
+	if len(s.S.Values) != 0 {
+		t.Errorf("Expected empty session, got %v", s.S.Values)
+	}
+}
+
+func TestSessionValuesNoCollision(t *testing.T) {
+	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
+	s.SetUserId(kUserId)
+	xsrfToken := s.NewXsrfToken("MyPage", kNow.Add(15*time.Minute))
+	for i := 0; i < 5; i++ {
+		s.SetValue(strconv.Itoa(i), "overwrite")
+		s.DeleteValue(strconv.Itoa(i))
+	}
+	s.SetValue("0", 0)
+	s.SetValue("1", 1)
+	s.SetValue("2", 2)
+	if id, ok := s.UserId(); !ok || id != kUserId {
+		t.Errorf("Expected user id %d, got %d", kUserId, id)
+	}
+	if !s.VerifyXsrfToken(xsrfToken, "MyPage", kNow) {
+		t.Error("Expected token to verify")
+	}
+	s.ClearAll()
+	if _, ok := s.GetValue("1"); ok {
+		t.Error("Expected ClearAll to clear values.")
+	}
+}
+
+func TestEstimatedSize(t *testing.T) {
+	// Callers register the value types they store.
+	gob.Register(time.Time{})
+	gob.Register(map[string]interface{}{})
+	s := session_util.UserIdSession{&sessions.Session{Values: make(map[interface{}]interface{})}}
+	empty := s.EstimatedSize()
+	if empty < 0 || empty > 64 {
+		t.Errorf("Expected small empty session, got %d", empty)
+	}
+	s.SetUserId(kUserId)
+	s.SetLastLogin(kNow)
+	withUser := s.EstimatedSize()
+	if withUser <= empty+64 {
+		t.Errorf("Expected size to grow past %d, got %d", empty+64, withUser)
+	}
+	s.SetValue("note", strings.Repeat("x", 1000))
+	if withValue := s.EstimatedSize(); withValue <= withUser+1000 {
+		t.Errorf("Expected size to grow past %d, got %d", withUser+1000, withValue)
+	}
+	s.SetValue("bad", make(chan int))
+	if size := s.EstimatedSize(); size != -1 {
+		t.Errorf("Expected -1 for unencodable session, got %d", size)
+	}
+}
+
 func TestSessionClearAll(t *testing.T) {
 	m := map[interface{}]interface{}{1: 2, 3: 4}
 	s := session_util.UserIdSession{&sessions.Session{Values: m}}
@@ -205,6 +372,238 @@ func TestUserSession(t *testing.T) {
 	if myUserSession != session_util.GetUserSession(r) {
 		t.Error("User session not stored with request.")
 	}
+	if stored, ok := session_util.TryGetUserSession(r); !ok || stored != myUserSession {
+		t.Error("Expected TryGetUserSession to find user session.")
+	}
+}
+
+func TestUserSessionRecordLastLogin(t *testing.T) {
+	userStore := store{kUserId}
+	sessionStore := newSessionStoreWithUserId(kSessionId, kUserId)
+	r := requestWithCookie(kSessionCookieName, kSessionId)
+	clock := date_util.NewFakeClock(kNow)
+	us, err := session_util.NewUserSession(
+		sessionStore,
+		r,
+		kSessionCookieName,
+		func(s *sessions.Session) session_util.UserSession {
+			return newUserSession(s)
+		},
+		userStore,
+		errNoSuchId,
+		session_util.RecordLastLogin(clock))
+	if err != nil {
+		t.Fatalf("An error happened getting userSession: %v", err)
+	}
+	defer context.Clear(r)
+	lastLogin, ok := us.(*userSession).LastLogin()
+	if !ok {
+		t.Fatal("Expected a last login")
+	}
+	if lastLogin != kNow {
+		t.Errorf("Expected %v, got %v", kNow, lastLogin)
+	}
+}
+
+func TestUserSessionRecordLastLoginNoUser(t *testing.T) {
+	userStore := store{kUserId + 1}
+	sessionStore := newSessionStoreWithUserId(kSessionId, kUserId)
+	r := requestWithCookie(kSessionCookieName, kSessionId)
+	us, err := session_util.NewUserSession(
+		sessionStore,
+		r,
+		kSessionCookieName,
+		func(s *sessions.Session) session_util.UserSession {
+			return newUserSession(s)
+		},
+		userStore,
+		errNoSuchId,
+		session_util.RecordLastLogin(date_util.NewFakeClock(kNow)))
+	if err != nil {
+		t.Fatalf("An error happened getting userSession: %v", err)
+	}
+	defer context.Clear(r)
+	if _, ok := us.(*userSession).LastLogin(); ok {
+		t.Error("Did not expect a last login without a user.")
+	}
+}
+
+func TestUserSessionRecordLastLoginOncePerLogin(t *testing.T) {
+	gs := &sessions.Session{Values: make(map[interface{}]interface{})}
+	s := session_util.UserIdSession{gs}
+	s.SetUserId(kUserId)
+	sessionStore := fixedStore{gs}
+	r := requestWithCookie(kSessionCookieName, kSessionId)
+	defer context.Clear(r)
+	newSession := func(now time.Time) {
+		_, err := session_util.NewUserSession(
+			sessionStore,
+			r,
+			kSessionCookieName,
+			func(s *sessions.Session) session_util.UserSession {
+				return newUserSession(s)
+			},
+			store{kUserId},
+			errNoSuchId,
+			session_util.RecordLastLogin(date_util.NewFakeClock(now)))
+		if err != nil {
+			t.Fatalf("An error happened getting userSession: %v", err)
+		}
+	}
+	assertLastLogin := func(expected time.Time) {
+		t.Helper()
+		if lastLogin, _ := s.LastLogin(); lastLogin != expected {
+			t.Errorf("Expected %v, got %v", expected, lastLogin)
+		}
+	}
+	newSession(kNow)
+	assertLastLogin(kNow)
+
+	// Later requests for the same login leave last login alone
+	newSession(kNow.Add(time.Hour))
+	assertLastLogin(kNow)
+
+	// SetUserId keeps last login until the next login is recorded
+	s.SetUserId(kUserId)
+	assertLastLogin(kNow)
+	newSession(kNow.Add(2 * time.Hour))
+	assertLastLogin(kNow.Add(2 * time.Hour))
+}
+
+func TestUserSessionRecordLastLoginUnsupported(t *testing.T) {
+	sessionStore := newSessionStoreWithUserId(kSessionId, kUserId)
+	r := requestWithCookie(kSessionCookieName, kSessionId)
+	defer context.Clear(r)
+	_, err := session_util.NewUserSession(
+		sessionStore,
+		r,
+		kSessionCookieName,
+		func(s *sessions.Session) session_util.UserSession {
+			return &bareUserSession{s}
+		},
+		store{kUserId},
+		errNoSuchId,
+		session_util.RecordLastLogin(date_util.NewFakeClock(kNow)))
+	if err == nil {
+		t.Error("Expected an error for a UserSession without SetLastLogin.")
+	}
+}
+
+func TestUserSessionLazy(t *testing.T) {
+	userStore := &countingStore{store: store{kUserId}}
+	sessionStore := newSessionStoreWithUserId(kSessionId, kUserId)
+	r := requestWithCookie(kSessionCookieName, kSessionId)
+	us, err := session_util.NewUserSession(
+		sessionStore,
+		r,
+		kSessionCookieName,
+		func(s *sessions.Session) session_util.UserSession {
+			return newLazyUserSession(s)
+		},
+		userStore,
+		errNoSuchId,
+		session_util.LoadUserLazily(),
+		session_util.RecordLastLogin(date_util.NewFakeClock(kNow)))
+	if err != nil {
+		t.Fatalf("An error happened getting userSession: %v", err)
+	}
+	defer context.Clear(r)
+	myUserSession := us.(*lazyUserSession)
+	if userStore.calls != 0 {
+		t.Errorf("Expected no GetUser calls, got %d", userStore.calls)
+	}
+	if _, ok := myUserSession.LastLogin(); ok {
+		t.Error("Did not expect a last login before user loads.")
+	}
+	for i := 0; i < 3; i++ {
+		userPtr, err := myUserSession.User()
+		if err != nil {
+			t.Fatalf("An error happened getting user: %v", err)
+		}
+		if output := *userPtr.(*int64); output != kUserId {
+			t.Errorf("Expected %v, got %v", kUserId, output)
+		}
+	}
+	if userStore.calls != 1 {
+		t.Errorf("Expected 1 GetUser call, got %d", userStore.calls)
+	}
+	if output := *myUserSession.Loaded; output != kUserId {
+		t.Errorf("Expected SetUser to be called with %v, got %v", kUserId, output)
+	}
+	if _, ok := myUserSession.LastLogin(); !ok {
+		t.Error("Expected a last login once user loads.")
+	}
+}
+
+func TestUserSessionLazyNoSuchIdAndError(t *testing.T) {
+	sessionStore := newSessionStoreWithUserId(kSessionId, kUserId)
+	r := requestWithCookie(kSessionCookieName, kSessionId)
+	defer context.Clear(r)
+	factory := func(s *sessions.Session) session_util.UserSession {
+		return newLazyUserSession(s)
+	}
+	us, err := session_util.NewUserSession(
+		sessionStore,
+		r,
+		kSessionCookieName,
+		factory,
+		store{kUserId + 1},
+		errNoSuchId,
+		session_util.LoadUserLazily())
+	if err != nil {
+		t.Fatalf("An error happened getting userSession: %v", err)
+	}
+	if userPtr, err := us.(*lazyUserSession).User(); userPtr != nil || err != nil {
+		t.Errorf("Expected no user and no error, got %v, %v", userPtr, err)
+	}
+	us, err = session_util.NewUserSession(
+		sessionStore,
+		r,
+		kSessionCookieName,
+		factory,
+		errorStore{},
+		errNoSuchId,
+		session_util.LoadUserLazily())
+	if err != nil {
+		t.Fatalf("Did not expect error before user loads: %v", err)
+	}
+	if _, err := us.(*lazyUserSession).User(); err != errDb {
+		t.Errorf("Expected %v, got %v", errDb, err)
+	}
+}
+
+func TestUserSessionLazyWithoutLazyUser(t *testing.T) {
+	sessionStore := newSessionStoreWithUserId(kSessionId, kUserId)
+	r := requestWithCookie(kSessionCookieName, kSessionId)
+	defer context.Clear(r)
+	_, err := session_util.NewUserSession(
+		sessionStore,
+		r,
+		kSessionCookieName,
+		func(s *sessions.Session) session_util.UserSession {
+			return newUserSession(s)
+		},
+		store{kUserId},
+		errNoSuchId,
+		session_util.LoadUserLazily())
+	if err == nil {
+		t.Error("Expected an error for a UserSession without LazyUser.")
+	}
+}
+
+func TestTryGetUserSessionNoSession(t *testing.T) {
+	r := requestWithCookie(kSessionCookieName, kSessionId)
+	defer context.Clear(r)
+	us, ok := session_util.TryGetUserSession(r)
+	if ok || us != nil {
+		t.Error("Did not expect a user session.")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected GetUserSession to panic.")
+		}
+	}()
+	session_util.GetUserSession(r)
 }
 
 func TestUserSessionNoSuchId(t *testing.T) {
@@ -307,6 +706,60 @@ func (u *userSession) SetUser(userPtr interface{}) {
 	u.User = userPtr.(*int64)
 }
 
+// bareUserSession has no LastLogin or SetLastLogin methods.
+type bareUserSession struct {
+	s *sessions.Session
+}
+
+func (b *bareUserSession) UserId() (int64, bool) {
+	return session_util.UserIdSession{b.s}.UserId()
+}
+
+func (b *bareUserSession) SetUser(userPtr interface{}) {
+}
+
+// fixedStore always returns the same session.
+type fixedStore struct {
+	s *sessions.Session
+}
+
+func (f fixedStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return f.s, nil
+}
+
+func (f fixedStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return f.s, nil
+}
+
+func (f fixedStore) Save(
+	r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	return nil
+}
+
+type lazyUserSession struct {
+	session_util.UserIdSession
+	session_util.LazyUser
+	Loaded *int64
+}
+
+func newLazyUserSession(s *sessions.Session) *lazyUserSession {
+	return &lazyUserSession{UserIdSession: session_util.UserIdSession{s}}
+}
+
+func (u *lazyUserSession) SetUser(userPtr interface{}) {
+	u.Loaded = userPtr.(*int64)
+}
+
+type countingStore struct {
+	store
+	calls int
+}
+
+func (c *countingStore) GetUser(id int64) (interface{}, error) {
+	c.calls++
+	return c.store.GetUser(id)
+}
+
 type store struct {
 	user int64
 }
@@ -325,6 +778,17 @@ func (s errorStore) GetUser(id int64) (interface{}, error) {
 	return nil, errDb
 }
 
+func csrfRequest(cookieValue, headerValue string) *http.Request {
+	r := httptest.NewRequest("POST", "/", nil)
+	if cookieValue != "" {
+		r.AddCookie(&http.Cookie{Name: "csrf", Value: cookieValue})
+	}
+	if headerValue != "" {
+		r.Header.Set("X-CSRF-Token", headerValue)
+	}
+	return r
+}
+
 func requestWithCookie(cookieName, cookieValue string) *http.Request {
 	cookieHeader := fmt.Sprintf("%s=%s", cookieName, cookieValue)
 	return &http.Request{Header: http.Header{"Cookie": {cookieHeader}}}
@@ -4,10 +4,21 @@ package str_util
 import (
 	"regexp"
 	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	re        = mustre(regexp.Compile("\\s+"))
+	nonSlugRe = mustre(regexp.Compile("[^a-z0-9]+"))
 )
 
 var (
-	re = mustre(regexp.Compile("\\s+"))
+	// kFoldLetters folds the lower case Latin letters that have no
+	// canonical decomposition.
+	kFoldLetters = strings.NewReplacer(
+		"đ", "d", "ł", "l", "ø", "o", "æ", "ae", "œ", "oe", "ß", "ss")
 )
 
 // Normalize normalizes a string for compare. It does by converting to
@@ -19,12 +30,87 @@ func Normalize(s string) string {
 			strings.ToLower(s)), " ")
 }
 
+// NormalizeFold works like Normalize except that it also replaces accented
+// Latin letters with their unaccented forms so that "Café" and "cafe"
+// normalize the same. NormalizeFold strips only the marks on Latin letters
+// and leaves letters of non-Latin scripts intact apart from composing them
+// into NFC form.
+func NormalizeFold(s string) string {
+	return norm.NFC.String(foldLatin(Normalize(s)))
+}
+
+// Slugify converts s to a URL safe identifier. Slugify lowercases s,
+// replaces accented Latin letters with their unaccented forms the same way
+// NormalizeFold does, and replaces each run of remaining characters other
+// than a-z and 0-9 with a single hyphen. The result never begins or ends
+// with a hyphen.
+// For example, "  Café Crème, 2nd Ed.!" becomes "cafe-creme-2nd-ed".
+func Slugify(s string) string {
+	folded := foldLatin(strings.ToLower(s))
+	return strings.Trim(nonSlugRe.ReplaceAllString(folded, "-"), "-")
+}
+
+// foldLatin returns s in NFD form with the marks on Latin letters removed
+// and the lower case Latin letters in kFoldLetters folded.
+func foldLatin(s string) string {
+	decomposed := norm.NFD.String(s)
+	var sb strings.Builder
+	sb.Grow(len(decomposed))
+	baseIsLatin := false
+	for _, r := range decomposed {
+		if unicode.Is(unicode.Mn, r) {
+			if !baseIsLatin {
+				sb.WriteRune(r)
+			}
+			continue
+		}
+		baseIsLatin = unicode.Is(unicode.Latin, r)
+		sb.WriteRune(r)
+	}
+	return kFoldLetters.Replace(sb.String())
+}
+
+// Truncate shortens s to at most maxRunes runes plus a trailing "…".
+// Truncate cuts s at the last word boundary at or before maxRunes and
+// appends "…" which is not counted against maxRunes. If s has no word
+// boundary within maxRunes runes, Truncate cuts s at exactly maxRunes
+// runes. If s already has at most maxRunes runes, Truncate returns s
+// unchanged. A negative maxRunes is treated as 0.
+func Truncate(s string, maxRunes int) string {
+	if maxRunes < 0 {
+		maxRunes = 0
+	}
+	runes := []rune(s)
+	if len(runes) <= maxRunes {
+		return s
+	}
+	end := maxRunes
+	if !unicode.IsSpace(runes[end]) {
+		for end > 0 && !unicode.IsSpace(runes[end-1]) {
+			end--
+		}
+		if end == 0 {
+			end = maxRunes
+		}
+	}
+	return strings.TrimRightFunc(string(runes[:end]), unicode.IsSpace) + "…"
+}
+
 // AutoComplete keeps track of auto-complete candidates.
+// The zero value holds an unlimited number of candidates.
 type AutoComplete struct {
 	// Items are the candidates so far with most recently added items at the end.
 	// Clients should not modify directly.
-	Items   []string
-	itemMap map[string]bool
+	Items    []string
+	itemMap  map[string]bool
+	maxItems int
+}
+
+// NewAutoComplete returns a new AutoComplete that holds at most maxItems
+// candidates. When full, adding a new candidate evicts the least recently
+// added candidate. A maxItems of 0 means no limit.
+func NewAutoComplete(maxItems int) *AutoComplete {
+	return &AutoComplete{maxItems: maxItems}
 }
 
 // Add adds another auto-complete candidate. If a candidate that equals s,
@@ -36,11 +122,40 @@ func (a *AutoComplete) Add(s string) {
 	}
 	lower := strings.ToLower(s)
 	if !a.itemMap[lower] {
+		if a.maxItems > 0 && len(a.Items) >= a.maxItems {
+			a.evictOldest()
+		}
 		a.itemMap[lower] = true
 		a.Items = append(a.Items, s)
 	}
 }
 
+// Contains returns true if a candidate equal to s, ignoring case, is
+// present.
+func (a *AutoComplete) Contains(s string) bool {
+	return s != "" && a.itemMap[strings.ToLower(s)]
+}
+
+func (a *AutoComplete) evictOldest() {
+	delete(a.itemMap, strings.ToLower(a.Items[0]))
+	copy(a.Items, a.Items[1:])
+	a.Items = a.Items[:len(a.Items)-1]
+}
+
+// Matches returns the candidates that start with prefix in the order they
+// were added. Matches compares the normalized forms of prefix and each
+// candidate. See Normalize.
+func (a *AutoComplete) Matches(prefix string) []string {
+	normalizedPrefix := Normalize(prefix)
+	var result []string
+	for _, item := range a.Items {
+		if strings.HasPrefix(Normalize(item), normalizedPrefix) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 func mustre(re *regexp.Regexp, err error) *regexp.Regexp {
 	if err != nil {
 		panic(err.Error())
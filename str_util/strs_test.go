@@ -11,6 +11,41 @@ func TestNormalize(t *testing.T) {
 	}
 }
 
+func TestNormalizeFold(t *testing.T) {
+	pairs := [][2]string{
+		{"Café", "cafe"},
+		{" Crème   BRÛLÉE ", "creme brulee"},
+		{"Ångström", "angstrom"},
+		{"Straße", "strasse"},
+		{"Zoë Šimić", "zoe simic"},
+		{"cafe\u0301", "cafe"},
+		{"Łódź Żółć", "lodz zolc"},
+		{"Ștefan Țepeș", "stefan tepes"},
+		{"Şcoală", "scoala"},
+		{"Tiếng Việt", "tieng viet"},
+		{"Đà Nẵng", "da nang"},
+	}
+	for _, pair := range pairs {
+		if output := NormalizeFold(pair[0]); output != pair[1] {
+			t.Errorf("Expected %q, got %q", pair[1], output)
+		}
+		if NormalizeFold(pair[0]) != NormalizeFold(pair[1]) {
+			t.Errorf("Expected %q and %q to match", pair[0], pair[1])
+		}
+	}
+	for _, s := range []string{"москва", "東京", "हिंदी", "αθήνα"} {
+		if output := NormalizeFold(s); output != s {
+			t.Errorf("Expected %q left intact, got %q", s, output)
+		}
+	}
+	if output := NormalizeFold("αθη\u0301να"); output != "αθήνα" {
+		t.Errorf("Expected decomposed Greek to compose, got %q", output)
+	}
+	if output := Normalize("Café"); output != "café" {
+		t.Errorf("Expected Normalize unchanged, got %q", output)
+	}
+}
+
 func TestAutoComplete(t *testing.T) {
 	ac := AutoComplete{}
 	ac.Add("") // Should be ignored
@@ -23,3 +58,113 @@ func TestAutoComplete(t *testing.T) {
 		t.Errorf("Expected %v, got %v", expected, ac.Items)
 	}
 }
+
+func TestAutoCompleteMatches(t *testing.T) {
+	ac := AutoComplete{}
+	ac.Add("Grocery Store")
+	ac.Add("Gas")
+	ac.Add("Rent")
+	ac.Add("grocery  delivery")
+	expected := []string{"Grocery Store", "grocery  delivery"}
+	if actual := ac.Matches(" GROCERY "); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	expected = []string{"Grocery Store", "Gas", "grocery  delivery"}
+	if actual := ac.Matches("g"); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	expected = []string{"grocery  delivery"}
+	if actual := ac.Matches("grocery d"); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	if actual := ac.Matches("x"); len(actual) != 0 {
+		t.Errorf("Expected no matches, got %v", actual)
+	}
+}
+
+func TestBoundedAutoComplete(t *testing.T) {
+	ac := NewAutoComplete(2)
+	ac.Add("Hello")
+	ac.Add("there")
+	ac.Add("HELLO") // Should be ignored, already "Hello"
+	ac.Add("you")
+	expected := []string{"there", "you"}
+	if !reflect.DeepEqual(expected, ac.Items) {
+		t.Errorf("Expected %v, got %v", expected, ac.Items)
+	}
+	if ac.Contains("hello") {
+		t.Error("Expected hello to be evicted")
+	}
+	if !ac.Contains("THERE") {
+		t.Error("Expected there to be present")
+	}
+
+	// Evicted candidates can be added again
+	ac.Add("hello")
+	expected = []string{"you", "hello"}
+	if !reflect.DeepEqual(expected, ac.Items) {
+		t.Errorf("Expected %v, got %v", expected, ac.Items)
+	}
+}
+
+func TestUnboundedAutoComplete(t *testing.T) {
+	ac := NewAutoComplete(0)
+	for _, s := range []string{"a", "b", "c", "d"} {
+		ac.Add(s)
+	}
+	if len(ac.Items) != 4 {
+		t.Errorf("Expected 4 items, got %v", ac.Items)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Hello World":            "hello-world",
+		"  Hello   World  ":      "hello-world",
+		"What's up, doc?":        "what-s-up-doc",
+		"--Go 1.18 -- Generics!": "go-1-18-generics",
+		"  Café Crème, 2nd Ed.!": "cafe-creme-2nd-ed",
+		"日本 tokyo":               "tokyo",
+		"Łódź Đakovo":            "lodz-dakovo",
+		"Erdős Şişli Doğan":      "erdos-sisli-dogan",
+		"Cafe\u0301 Cre\u0300me": "cafe-creme",
+		"Ærø Straße":             "aero-strasse",
+		"!@#$%^&*()":             "",
+		"":                       "",
+	}
+	for input, expected := range cases {
+		if actual := Slugify(input); actual != expected {
+			t.Errorf("Slugify(%q): expected %q, got %q", input, expected, actual)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		input    string
+		maxRunes int
+		expected string
+	}{
+		{"The quick brown fox", 100, "The quick brown fox"},
+		{"The quick brown fox", 19, "The quick brown fox"},
+		{"The quick brown fox", 18, "The quick brown…"},
+		{"The quick brown fox", 15, "The quick brown…"},
+		{"The quick brown fox", 12, "The quick…"},
+		{"The quick  brown fox", 11, "The quick…"},
+		{"Supercalifragilistic", 5, "Super…"},
+		{"Crème brûlée à la mode", 13, "Crème brûlée…"},
+		{"日本語のテキスト", 3, "日本語…"},
+		{"日本語", 3, "日本語"},
+		{"", 0, ""},
+		{"abc", 0, "…"},
+		{"abc", -1, "…"},
+		{"", -1, ""},
+	}
+	for _, c := range cases {
+		if actual := Truncate(c.input, c.maxRunes); actual != c.expected {
+			t.Errorf(
+				"Truncate(%q, %d): expected %q, got %q",
+				c.input, c.maxRunes, c.expected, actual)
+		}
+	}
+}